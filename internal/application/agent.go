@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/zakharkaverin1/final_calca/pkg/calculation"
@@ -18,9 +19,21 @@ type Response struct {
 	Res float64 `json:"res"`
 }
 
+// AgentVersion сообщается оркестратору при регистрации
+const AgentVersion = "1.1.0"
+
 type Agent struct {
-	power int
-	url   string
+	power    int
+	url      string
+	id       string
+	hostname string
+	secret   string
+	pollWait time.Duration
+
+	mu    sync.Mutex
+	token string
+	// regMu не даёт нескольким демонам перерегистрироваться одновременно
+	regMu sync.Mutex
 }
 
 func NewAgent() *Agent {
@@ -28,89 +41,289 @@ func NewAgent() *Agent {
 	if err != nil {
 		p = 1
 	}
-	return &Agent{power: p, url: "http://localhost:8080"}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	id := os.Getenv("AGENT_ID")
+	if id == "" {
+		suffix, _ := generateRandomID(6)
+		id = hostname + "-" + suffix
+	}
+	wait, err := strconv.Atoi(os.Getenv("AGENT_POLL_WAIT_MS"))
+	if err != nil || wait <= 0 {
+		wait = 30000
+	}
+	return &Agent{
+		power:    p,
+		pollWait: time.Duration(wait) * time.Millisecond,
+		url:      "http://localhost:8080",
+		id:       id,
+		hostname: hostname,
+		secret:   os.Getenv("AGENT_SECRET"),
+	}
 }
 
 func (a *Agent) Run() {
-	for i := 0; i < a.power; i++ {
-		log.Printf("Начинаем работу демона номер %d", i)
-		go a.worker(i)
-	}
-	select {}
+	interval := a.register()
+	go a.heartbeat(interval)
+	results := make(chan TaskResult, a.power)
+	go a.reporter(results)
+	log.Printf("Агент %s: начинаем работу, слотов %d", a.id, a.power)
+	a.fetcher(results)
 }
-func (a *Agent) worker(id int) {
+
+// register регистрирует агента по общему секрету AGENT_SECRET и запоминает
+// выданный оркестратором токен. Повторяет попытки, пока оркестратор не ответит.
+// Возвращает интервал heartbeat, который просит оркестратор.
+func (a *Agent) register() time.Duration {
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":              a.id,
+		"hostname":        a.hostname,
+		"computing_power": a.power,
+		"version":         AgentVersion,
+	})
 	for {
-		resp, err := http.Get(a.url + "/internal/task")
+		req, err := http.NewRequest(http.MethodPost, a.url+"/internal/agents/register", bytes.NewReader(body))
 		if err != nil {
-			log.Printf("Демон %d: ошибка получения задачи: %v", id, err)
+			log.Fatalf("Агент %s: ошибка запроса регистрации: %v", a.id, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+a.secret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Printf("Агент %s: ошибка регистрации: %v", a.id, err)
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		log.Printf("Демон %d: GET /internal/task → %d", id, resp.StatusCode)
-		if resp.StatusCode == http.StatusNotFound {
-			resp.Body.Close()
+		var reg struct {
+			Token               string `json:"token"`
+			HeartbeatIntervalMs int64  `json:"heartbeat_interval_ms"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&reg)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil {
+			log.Printf("Агент %s: регистрация отклонена, статус %d", a.id, resp.StatusCode)
 			time.Sleep(1 * time.Second)
 			continue
 		}
-
-		var taskResponse struct {
-			Task struct {
-				ID            string  `json:"id"`
-				Arg1          float64 `json:"arg1"`
-				Arg2          float64 `json:"arg2"`
-				Operation     string  `json:"operation"`
-				OperationTime int     `json:"operation_time"`
-			} `json:"task"`
+		a.mu.Lock()
+		a.token = reg.Token
+		a.mu.Unlock()
+		log.Printf("Агент %s зарегистрирован", a.id)
+		if reg.HeartbeatIntervalMs <= 0 {
+			return 5 * time.Second
 		}
+		return time.Duration(reg.HeartbeatIntervalMs) * time.Millisecond
+	}
+}
 
-		if err := json.NewDecoder(resp.Body).Decode(&taskResponse); err != nil {
-			log.Printf("Демон %d: ошибка парсинга задачи: %v", id, err)
-			resp.Body.Close()
+// reauth перерегистрирует агента, если отвергнутый токен всё ещё текущий:
+// другой демон мог уже получить новый
+func (a *Agent) reauth(rejected string) {
+	a.regMu.Lock()
+	defer a.regMu.Unlock()
+	a.mu.Lock()
+	stale := a.token == rejected
+	a.mu.Unlock()
+	if stale {
+		a.register()
+	}
+}
+
+func (a *Agent) heartbeat(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		resp, err := a.post("/internal/agents/heartbeat", nil)
+		if err != nil {
+			log.Printf("Агент %s: ошибка heartbeat: %v", a.id, err)
 			continue
 		}
 		resp.Body.Close()
+	}
+}
 
-		time.Sleep(time.Duration(taskResponse.Task.OperationTime) * time.Millisecond)
+// do отправляет запрос с ID и токеном агента. Если оркестратор токен не
+// принял (например, после перезапуска), агент регистрируется заново.
+func (a *Agent) do(req *http.Request) (*http.Response, error) {
+	a.mu.Lock()
+	token := a.token
+	a.mu.Unlock()
+	req.Header.Set(agentHeaderID, a.id)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		log.Printf("Агент %s: токен отклонён, регистрируемся заново", a.id)
+		a.reauth(token)
+	}
+	return resp, err
+}
 
-		result, err := calculation.Compute(
-			taskResponse.Task.Operation,
-			taskResponse.Task.Arg1,
-			taskResponse.Task.Arg2,
-		)
-		if err != nil {
-			log.Printf("Демон %d: ошибка вычисления: %v", id, err)
-			continue
+func (a *Agent) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, a.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return a.do(req)
+}
+
+func (a *Agent) post(path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, a.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return a.do(req)
+}
+
+// agentTask — задача в том виде, в каком её выдаёт GET /internal/task
+type agentTask struct {
+	ID            string    `json:"id"`
+	LeaseID       string    `json:"lease_id"`
+	Arg1          Number    `json:"arg1"`
+	Arg2          Number    `json:"arg2"`
+	Args          []Number  `json:"args"`
+	ExactArgs     []string  `json:"exact_args"`
+	Operation     string    `json:"operation"`
+	OperationTime int       `json:"operation_time"`
+}
+
+// fetcher одним запросом забирает столько задач, сколько сейчас свободно
+// слотов (COMPUTING_POWER), и запускает по вычислителю на каждую.
+func (a *Agent) fetcher(results chan<- TaskResult) {
+	slots := make(chan struct{}, a.power)
+	for {
+		// ждём хотя бы один свободный слот, затем занимаем все остальные свободные
+		slots <- struct{}{}
+		free := 1
+	take:
+		for free < a.power {
+			select {
+			case slots <- struct{}{}:
+				free++
+			default:
+				break take
+			}
 		}
 
-		response := map[string]interface{}{
-			"task_id": taskResponse.Task.ID,
-			"result":  result,
+		tasks, err := a.fetch(free)
+		if err != nil {
+			log.Printf("Агент %s: ошибка получения задач: %v", a.id, err)
+			time.Sleep(1 * time.Second)
 		}
+		for _, task := range tasks {
+			go func(task agentTask) {
+				results <- a.execute(task)
+				<-slots
+			}(task)
+		}
+		// слоты, на которые задач не хватило, освобождаем
+		for i := len(tasks); i < free; i++ {
+			<-slots
+		}
+	}
+}
 
-		jsonResp, _ := json.Marshal(response)
-		fmt.Println(result)
+// fetch запрашивает до n задач. Оркестратор держит запрос, пока не появится
+// задача или не выйдет время ожидания; тогда возвращается пустой список.
+func (a *Agent) fetch(n int) ([]agentTask, error) {
+	resp, err := a.get(fmt.Sprintf("/internal/task?max=%d&wait=%s", n, a.pollWait))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET /internal/task → %d", resp.StatusCode)
+	}
+	var taskResponse struct {
+		Tasks []agentTask `json:"tasks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&taskResponse); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга задач: %w", err)
+	}
+	log.Printf("Агент %s: получено задач %d", a.id, len(taskResponse.Tasks))
+	return taskResponse.Tasks, nil
+}
 
-		respPost, err := http.Post(
-			a.url+"/internal/task",
-			"application/json",
-			bytes.NewBuffer(jsonResp),
-		)
-		if err != nil {
-			log.Printf("Демон %d: ошибка POST результата: %v", id, err)
-		} else {
-			log.Printf("Демон %d: POST /internal/task → %d", id, respPost.StatusCode)
+func (a *Agent) execute(task agentTask) TaskResult {
+	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+
+	res := TaskResult{TaskID: task.ID, LeaseID: task.LeaseID}
+	var (
+		v   float64
+		err error
+	)
+	if len(task.ExactArgs) > 0 {
+		// точный режим: результат уходит строкой, число — для событий
+		res.Exact, err = calculation.ComputeExact(task.Operation, task.ExactArgs)
+		if err == nil {
+			v, _ = calculation.ExactFloat(res.Exact)
 		}
-		
-		log.Printf("Демон %d: POST /internal/task → %d", id, respPost.StatusCode)
-		if err != nil {
-			log.Printf("Демон %d: ошибка отправки результата: %v", id, err)
-			continue
+	} else if calculation.IsFunc(task.Operation) {
+		v, err = calculation.ComputeFunc(task.Operation, calculation.Floats(task.Args))
+	} else {
+		v, err = calculation.Compute(task.Operation, float64(task.Arg1), float64(task.Arg2))
+	}
+	res.Result = Number(v)
+	if err != nil {
+		// сообщаем оркестратору, иначе выражение так и останется в работе
+		log.Printf("Агент %s: ошибка вычисления задачи %s: %v", a.id, task.ID, err)
+		res.Result = 0
+		res.Exact = ""
+		res.Error = &TaskError{Code: calculation.ErrorCode(err), Message: err.Error()}
+	}
+	return res
+}
+
+// reporter отправляет результаты пачками: всё, что накопилось к моменту
+// отправки, уходит одним POST /internal/task.
+func (a *Agent) reporter(results <-chan TaskResult) {
+	for res := range results {
+		batch := []TaskResult{res}
+	drain:
+		for {
+			select {
+			case more := <-results:
+				batch = append(batch, more)
+			default:
+				break drain
+			}
 		}
+		a.report(batch)
+	}
+}
 
-		if respPost.StatusCode != http.StatusOK {
-			log.Printf("Демон %d: сервер вернул статус %d", id, respPost.StatusCode)
+func (a *Agent) report(batch []TaskResult) {
+	body, _ := json.Marshal(map[string]interface{}{"results": batch})
+	resp, err := a.post("/internal/task", body)
+	if err != nil {
+		log.Printf("Агент %s: ошибка отправки результатов: %v", a.id, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Агент %s: POST /internal/task → %d", a.id, resp.StatusCode)
+		return
+	}
+	var statusResponse struct {
+		Results []ResultStatus `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&statusResponse); err != nil {
+		log.Printf("Агент %s: ошибка парсинга ответа: %v", a.id, err)
+		return
+	}
+	for _, st := range statusResponse.Results {
+		if st.Code == http.StatusGone {
+			log.Printf("Агент %s: выражение задачи %s снято с вычисления, результат не нужен", a.id, st.TaskID)
+			continue
+		}
+		if st.Code != http.StatusOK {
+			log.Printf("Агент %s: результат задачи %s не принят (%d): %s", a.id, st.TaskID, st.Code, st.Error)
 			continue
 		}
-		log.Printf("Демон %d: успешно обработал задачу %s", id, taskResponse.Task.ID)
+		log.Printf("Агент %s: успешно обработал задачу %s", a.id, st.TaskID)
 	}
 }
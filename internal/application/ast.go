@@ -1,104 +1,39 @@
 package application
 
-import (
-	"fmt"
-	"strconv"
-	"strings"
-	"unicode"
+import "github.com/zakharkaverin1/final_calca/pkg/calculation"
+
+// Грамматика выражений живёт в pkg/calculation, чтобы ей пользовался и
+// локальный calculation.Calc, и распределённое вычисление.
+type (
+	ASTNode       = calculation.ASTNode
+	SyntaxError   = calculation.SyntaxError
+	Rewrite       = calculation.Rewrite
+	FormatOptions = calculation.FormatOptions
+	Number        = calculation.Number
+	Variable      = calculation.Variable
 )
 
-type ASTNode struct {
-	IsLeaf   bool
-	Value    float64
-	Operator string
-	Left     *ASTNode
-	Right    *ASTNode
-}
-
-type parser struct {
-	input string
-	pos   int
-}
-
+// ParseAST разбирает выражение в дерево. Ошибки разбора имеют тип *SyntaxError.
 func ParseAST(expr string) (*ASTNode, error) {
-	expr = strings.ReplaceAll(expr, " ", "")
-	p := &parser{input: expr}
-	ast, err := p.parseExpression()
-	if err != nil {
-		return nil, err
-	}
-	if p.pos != len(p.input) {
-		return nil, fmt.Errorf("unexpected input at %d", p.pos)
-	}
-	return ast, nil
+	return calculation.ParseAST(expr)
 }
 
-func (p *parser) parseExpression() (*ASTNode, error) {
-	return p.parseBinaryOp(p.parseTerm, []string{"+", "-"})
-}
-
-func (p *parser) parseTerm() (*ASTNode, error) {
-	return p.parseBinaryOp(p.parseFactor, []string{"*", "/"})
-}
-
-func (p *parser) parseBinaryOp(next func() (*ASTNode, error), ops []string) (*ASTNode, error) {
-	node, err := next()
-	if err != nil {
-		return nil, err
-	}
-	for {
-		if p.pos >= len(p.input) {
-			break
-		}
-		matched := ""
-		for _, op := range ops {
-			if string(p.input[p.pos]) == op {
-				matched = op
-				break
-			}
-		}
-		if matched == "" {
-			break
-		}
-		p.pos++
-		right, err := next()
-		if err != nil {
-			return nil, err
-		}
-		node = &ASTNode{Operator: matched, Left: node, Right: right}
+func child(n *ASTNode, i int) *ASTNode {
+	children := n.Children()
+	if i < 0 || i >= len(children) {
+		return nil
 	}
-	return node, nil
+	return children[i]
 }
 
-func (p *parser) parseFactor() (*ASTNode, error) {
-	if p.pos < len(p.input) && p.input[p.pos] == '(' {
-		p.pos++
-		node, err := p.parseExpression()
-		if err != nil {
-			return nil, err
-		}
-		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
-			return nil, fmt.Errorf("missing )")
+// nodeAt находит узел по пути из индексов потомков, начиная от корня
+func nodeAt(root *ASTNode, path []int) *ASTNode {
+	n := root
+	for _, i := range path {
+		if n == nil {
+			return nil
 		}
-		p.pos++
-		return node, nil
-	}
-	start := p.pos
-	if p.pos < len(p.input) && (p.input[p.pos] == '+' || p.input[p.pos] == '-') {
-		p.pos++
+		n = child(n, i)
 	}
-	for p.pos < len(p.input) && (unicode.IsDigit(rune(p.input[p.pos])) || p.input[p.pos] == '.') {
-		p.pos++
-	}
-	numStr := p.input[start:p.pos]
-	if numStr == "" {
-		return nil, fmt.Errorf("expected number")
-	}
-	val, err := strconv.ParseFloat(numStr, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid number %q", numStr)
-	}
-	return &ASTNode{IsLeaf: true, Value: val}, nil
+	return n
 }
-
-
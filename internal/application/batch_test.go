@@ -0,0 +1,49 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestPostTask_BatchStatuses(t *testing.T) {
+	f := newFixture(t)
+	f.submit(`{"expression":"1+2"}`)
+	f.submit(`{"expression":"3+4"}`)
+
+	rec := serve(f.o.getTaskHandler, http.MethodGet, "/internal/task?max=10", "", agentHeader("a1"))
+	var leased struct {
+		Tasks []*Task `json:"tasks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&leased); err != nil || len(leased.Tasks) != 2 {
+		t.Fatalf("Ожидались 2 задачи, получено %s", rec.Body)
+	}
+
+	stale := compute(t, leased.Tasks[1])
+	stale.LeaseID = "stale"
+	batch := map[string][]TaskResult{"results": {compute(t, leased.Tasks[0]), stale, {TaskID: "missing"}}}
+	body, _ := json.Marshal(batch)
+	rec = serve(f.o.postTaskHandler, http.MethodPost, "/internal/task", string(body), agentHeader("a1"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Ожидался код 200, получен %d", rec.Code)
+	}
+	var resp struct {
+		Results []ResultStatus `json:"results"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Неверный ответ: %v", err)
+	}
+	want := []ResultStatus{
+		{TaskID: leased.Tasks[0].ID, Status: "ok", Code: http.StatusOK},
+		{TaskID: leased.Tasks[1].ID, Status: "error", Code: http.StatusConflict},
+		{TaskID: "missing", Status: "error", Code: http.StatusNotFound},
+	}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("Получено %+v", resp.Results)
+	}
+	for i, st := range resp.Results {
+		if st.TaskID != want[i].TaskID || st.Status != want[i].Status || st.Code != want[i].Code {
+			t.Errorf("Результат %d: получено %+v, ожидалось %+v", i, st, want[i])
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package application
+
+import (
+	"log"
+)
+
+// Кэш результатов: выражения сравниваются по канонической записи AST
+// (ASTNode.String). Если такое выражение уже вычислено, результат берётся
+// из БД сразу. Если оно ещё считается, новое выражение становится ведомым:
+// задач у него нет, результат или ошибку оно получит от ведущего. Флаг
+// no_cache в запросе отключает и то, и другое.
+
+// follower — выражение, ждущее результата одинакового с ним ведущего
+type follower struct {
+	exprID string
+	ast    *ASTNode
+}
+
+// reuseCached завершает выражение готовым результатом одинакового выражения,
+// если такое есть. Вызывается под o.mu.
+func (o *Orchestrator) reuseCached(exprID, normalized string) (bool, error) {
+	sourceID, result, err := FindSaymExpression(normalized)
+	if err != nil {
+		return false, nil
+	}
+	if err := SetExpressionSource(exprID, sourceID); err != nil {
+		return false, err
+	}
+	log.Printf("Выражение %s взято из кэша (%s)", exprID, sourceID)
+	return true, o.complete(exprID, result)
+}
+
+// follow делает выражение ведомым, если одинаковое уже считается.
+// Вызывается под o.mu.
+func (o *Orchestrator) follow(exprID, normalized string, ast *ASTNode) (bool, error) {
+	leaderID, ok := o.inflight[normalized]
+	if !ok {
+		return false, nil
+	}
+	if err := SetExpressionSource(exprID, leaderID); err != nil {
+		return false, err
+	}
+	o.followers[leaderID] = append(o.followers[leaderID], &follower{exprID: exprID, ast: ast})
+	log.Printf("Выражение %s ждёт результата %s", exprID, leaderID)
+	return true, nil
+}
+
+// lead отмечает выражение как считающееся, чтобы одинаковые присоединялись к нему
+func (o *Orchestrator) lead(exprID, normalized string) {
+	if _, ok := o.inflight[normalized]; !ok {
+		o.inflight[normalized] = exprID
+	}
+}
+
+// unfollow убирает выражение из ведомых, например при отмене
+func (o *Orchestrator) unfollow(exprID string) {
+	for leaderID, fs := range o.followers {
+		for i, f := range fs {
+			if f.exprID != exprID {
+				continue
+			}
+			fs = append(fs[:i], fs[i+1:]...)
+			if len(fs) == 0 {
+				delete(o.followers, leaderID)
+			} else {
+				o.followers[leaderID] = fs
+			}
+			return
+		}
+	}
+}
+
+// takeFollowers забирает ведомых выражения
+func (o *Orchestrator) takeFollowers(leaderID string) []*follower {
+	fs := o.followers[leaderID]
+	delete(o.followers, leaderID)
+	return fs
+}
+
+// promoteFollower передаёт вычисление первому ведомому, когда ведущее
+// выражение отменено или не успело к дедлайну
+func (o *Orchestrator) promoteFollower(leaderID string) {
+	fs := o.takeFollowers(leaderID)
+	if len(fs) == 0 {
+		return
+	}
+	next, rest := fs[0], fs[1:]
+	if err := SetExpressionSource(next.exprID, ""); err != nil {
+		log.Printf("Ошибка обновления выражения %s: %v", next.exprID, err)
+	}
+	for _, f := range rest {
+		if err := SetExpressionSource(f.exprID, next.exprID); err != nil {
+			log.Printf("Ошибка обновления выражения %s: %v", f.exprID, err)
+		}
+	}
+	if len(rest) > 0 {
+		o.followers[next.exprID] = rest
+	}
+	o.astStore[next.exprID] = next.ast
+	if meta, ok := o.exprs[next.exprID]; ok {
+		o.inflight[meta.Normalized] = next.exprID
+	}
+	log.Printf("Выражение %s считается вместо %s", next.exprID, leaderID)
+	if !o.linkReferences(next.exprID) {
+		return
+	}
+	if err := o.advance(next.exprID); err != nil {
+		log.Printf("Ошибка запуска выражения %s: %v", next.exprID, err)
+	}
+}
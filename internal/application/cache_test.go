@@ -0,0 +1,46 @@
+package application
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCache_FollowerPromotionAndReuse(t *testing.T) {
+	f := newFixture(t)
+	leader := f.submit(`{"expression":"2*3"}`)
+	follower := f.submit(`{"expression":"2 * 3"}`)
+	if leader.Shared || !follower.Shared {
+		t.Fatalf("Второе выражение должно ждать первое: %+v, %+v", leader, follower)
+	}
+	stale, ok := f.lease("a1", "")
+	if !ok {
+		t.Fatal("Задача не выдана")
+	}
+
+	// отмена ведущего передаёт вычисление ведомому
+	if rec := f.cancel(leader.Id, f.token); rec.Code != http.StatusOK {
+		t.Fatalf("Ожидался код 200, получен %d: %s", rec.Code, rec.Body)
+	}
+	if code := f.report("a1", compute(t, stale)); code != http.StatusGone {
+		t.Errorf("Ожидался код 410, получен %d", code)
+	}
+	f.drain()
+	if status, result := f.status(follower.Id); status != 3 || result != "6" {
+		t.Fatalf("Получено %d, %q", status, result)
+	}
+
+	cached := f.submit(`{"expression":"2*3"}`)
+	if !cached.Cached {
+		t.Fatalf("Ожидался результат из кэша: %+v", cached)
+	}
+	if status, result := f.status(cached.Id); status != 3 || result != "6" {
+		t.Errorf("Получено %d, %q", status, result)
+	}
+	if _, ok := f.lease("a1", ""); ok {
+		t.Error("Выражение из кэша не должно ставить задачи")
+	}
+	// no_cache считает заново
+	if resp := f.submit(`{"expression":"2*3","no_cache":true}`); resp.Cached || resp.Shared {
+		t.Errorf("Ожидалось новое вычисление: %+v", resp)
+	}
+}
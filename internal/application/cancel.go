@@ -0,0 +1,95 @@
+package application
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Отмена выражения: задачи из очереди снимаются сразу, а задачи в аренде
+// остаются в taskList с пометкой Cancelled, чтобы агент получил 410 на свой
+// результат. Если результата так и не будет, их уберёт watchLeases.
+
+// cancelExpressionHandler обрабатывает DELETE /api/v1/expressions/{id}
+func (o *Orchestrator) cancelExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		http.Error(w, "неверный Authorization header", http.StatusUnauthorized)
+		return
+	}
+	tokenStr := strings.TrimPrefix(auth, "Bearer ")
+	claims, err := ParseJWT(tokenStr)
+	if err != nil {
+		http.Error(w, "неверный токен", http.StatusUnauthorized)
+		return
+	}
+	userID := claims.UserID
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 {
+		http.Error(w, "что-то пошло не так", http.StatusBadRequest)
+		return
+	}
+	exprID := parts[4]
+
+	// статус читаем под o.mu: выражение может как раз досчитаться
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	expr, code, msg := getUserExpression(exprID, userID)
+	if expr == nil {
+		http.Error(w, msg, code)
+		return
+	}
+	if expr.StatusID != 1 && expr.StatusID != 2 {
+		http.Error(w, "выражение уже завершено", http.StatusConflict)
+		return
+	}
+
+	o.cancelExpression(exprID)
+	if err := UpdateExpression(exprID, 5, nil); err != nil {
+		http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	o.finish(exprID)
+	o.emit(Event{Type: EventExpressionCancelled, ExprID: exprID, UserID: userID, Status: getStatusName(5)})
+	log.Printf("Выражение %s отменено", exprID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": exprID, "status": getStatusName(5)})
+}
+
+// cancelExpression снимает выражение с расписания. Вызывается под o.mu.
+func (o *Orchestrator) cancelExpression(exprID string) {
+	o.unfollow(exprID)
+	list := o.taskList[:0]
+	var handed []*Task
+	for _, t := range o.taskList {
+		if t.ExprID != exprID {
+			list = append(list, t)
+			continue
+		}
+		primary := t.SharedWith == nil
+		if next := o.detach(t); next != nil {
+			// результат всё ещё нужен другим задачам, аренда перешла к ним
+			if next.ExprID != exprID {
+				handed = append(handed, next)
+			}
+			continue
+		}
+		if primary && t.LeaseID != "" {
+			t.Cancelled = true
+			t.Node = nil
+			list = append(list, t)
+		}
+	}
+	o.taskList = list
+	o.taskQueue.Remove(exprID)
+	// задачи переходят с тем же ID, поэтому сохранённое состояние отменённого
+	// выражения удаляется раньше, чем записываются задачи получателей
+	o.forgetExpression(exprID)
+	for _, t := range handed {
+		o.persistExpression(t.ExprID)
+	}
+	o.promoteFollower(exprID)
+}
@@ -0,0 +1,36 @@
+package application
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCancel_LateResultIsGone(t *testing.T) {
+	f := newFixture(t)
+	id := f.submit(`{"expression":"2+3"}`).Id
+	task, ok := f.lease("a1", "")
+	if !ok {
+		t.Fatal("Задача не выдана")
+	}
+
+	if rec := f.cancel(id, f.token); rec.Code != http.StatusOK {
+		t.Fatalf("Ожидался код 200, получен %d: %s", rec.Code, rec.Body)
+	}
+	if status, _ := f.status(id); status != 5 {
+		t.Errorf("Ожидался статус 5, получен %d", status)
+	}
+	if code := f.report("a1", compute(t, task)); code != http.StatusGone {
+		t.Errorf("Ожидался код 410, получен %d", code)
+	}
+	// после 410 задача забыта
+	if code := f.report("a1", compute(t, task)); code != http.StatusNotFound {
+		t.Errorf("Ожидался код 404, получен %d", code)
+	}
+
+	if rec := f.cancel(id, f.token); rec.Code != http.StatusConflict {
+		t.Errorf("Ожидался код 409, получен %d", rec.Code)
+	}
+	if rec := f.cancel(id, f.userToken("u2")); rec.Code != http.StatusForbidden {
+		t.Errorf("Ожидался код 403, получен %d", rec.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package application
+
+// Общие подвыражения: готовый к вычислению узел получает ключ — каноническую
+// запись (операция и значения аргументов). Если задача с таким ключом уже
+// стоит в очереди или в аренде, новая задача не ставится в очередь, а ждёт
+// её результата. Это работает и внутри одного выражения ("(2*3)+(2*3)"), и
+// между разными выражениями.
+
+// share регистрирует новую задачу: делает её основной для своего ключа или
+// подписывает на уже существующую. Возвращает true, если задачу нужно
+// поставить в очередь.
+func (o *Orchestrator) share(task *Task) bool {
+	primary, ok := o.shared[task.Key]
+	if !ok {
+		o.shared[task.Key] = task
+		return true
+	}
+	task.SharedWith = primary
+	primary.Waiters = append(primary.Waiters, task)
+	// общая задача выдаётся с наибольшим приоритетом из ждущих
+	if task.Priority > primary.Priority {
+		primary.Priority = task.Priority
+	}
+	return false
+}
+
+// settle снимает с расписания задачи, ждавшие результата основной, и
+// возвращает их. Вызывается, когда агент прислал результат основной задачи.
+func (o *Orchestrator) settle(primary *Task) []*Task {
+	if o.shared[primary.Key] == primary {
+		delete(o.shared, primary.Key)
+	}
+	waiters := primary.Waiters
+	primary.Waiters = nil
+	if len(waiters) == 0 {
+		return nil
+	}
+	waiting := make(map[*Task]bool, len(waiters))
+	for _, w := range waiters {
+		w.SharedWith = nil
+		waiting[w] = true
+	}
+	list := o.taskList[:0]
+	for _, t := range o.taskList {
+		if !waiting[t] {
+			list = append(list, t)
+		}
+	}
+	o.taskList = list
+	return waiters
+}
+
+// detach отвязывает удаляемую задачу от общего вычисления. Если её
+// результата ждут другие задачи, вычисление (вместе с арендой) переходит к
+// первой из них, и она возвращается.
+func (o *Orchestrator) detach(t *Task) *Task {
+	if p := t.SharedWith; p != nil {
+		for i, w := range p.Waiters {
+			if w == t {
+				p.Waiters = append(p.Waiters[:i], p.Waiters[i+1:]...)
+				break
+			}
+		}
+		t.SharedWith = nil
+		return nil
+	}
+	if o.shared[t.Key] == t {
+		delete(o.shared, t.Key)
+	}
+	if len(t.Waiters) == 0 {
+		return nil
+	}
+	next := t.Waiters[0]
+	next.Waiters = t.Waiters[1:]
+	next.SharedWith = nil
+	for _, w := range next.Waiters {
+		w.SharedWith = next
+	}
+	t.Waiters = nil
+	// агент отчитается по ID и аренде прежней задачи
+	next.ID = t.ID
+	next.LeaseID = t.LeaseID
+	next.LeaseDeadline = t.LeaseDeadline
+	next.AgentID = t.AgentID
+	next.QueuedAt = t.QueuedAt
+	o.shared[next.Key] = next
+	if next.LeaseID == "" {
+		o.taskQueue.PushFront(next)
+		o.notifyTasks()
+	}
+	return next
+}
@@ -0,0 +1,90 @@
+package application
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// Первое выражение отменяется или падает, пока общая задача стоит в очереди:
+// задача переходит ко второму, и ждущие агенты должны об этом узнать.
+func TestDetach_HandsSharedTaskOver(t *testing.T) {
+	for _, how := range []string{"cancel", "fail"} {
+		t.Run(how, func(t *testing.T) {
+			f := newFixture(t)
+			first := f.submit(`{"expression":"(2*3)+(1/0)"}`).Id
+			second := f.submit(`{"expression":"(2*3)+2"}`).Id
+
+			f.o.mu.Lock()
+			signal := f.o.taskSignal
+			var shared string
+			for _, task := range f.o.taskList {
+				if task.ExprID == first && task.Operation == "*" {
+					shared = task.ID
+				}
+			}
+			f.o.mu.Unlock()
+			switch how {
+			case "cancel":
+				if rec := f.cancel(first, f.token); rec.Code != http.StatusOK {
+					t.Fatalf("Ожидался код 200, получен %d: %s", rec.Code, rec.Body)
+				}
+			case "fail":
+				task, ok := f.lease("a1", "")
+				if !ok || task.Operation != "/" {
+					t.Fatalf("Ожидалась задача деления, получено %+v", task)
+				}
+				f.o.mu.Lock()
+				signal = f.o.taskSignal
+				f.o.mu.Unlock()
+				res := TaskResult{TaskID: task.ID, LeaseID: task.LeaseID, Error: &TaskError{Code: "division_by_zero", Message: "деление на ноль"}}
+				if code := f.report("a1", res); code != http.StatusOK {
+					t.Fatalf("Ожидался код 200, получен %d", code)
+				}
+			}
+
+			select {
+			case <-signal:
+			default:
+				t.Error("Ожидающие агенты не разбужены")
+			}
+
+			// переданная задача должна быть в сохранённом состоянии второго выражения
+			pending, err := LoadPendingExpressions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var saved []string
+			for _, p := range pending {
+				if p.ID != second {
+					continue
+				}
+				for _, task := range p.Tasks {
+					saved = append(saved, task.ID)
+				}
+			}
+			if !slices.Contains(saved, shared) {
+				t.Fatalf("Задача %s не сохранена за вторым выражением: %v", shared, saved)
+			}
+			f.restart()
+			f.drain()
+			if status, result := f.status(second); status != 3 || result != "8" {
+				t.Errorf("Получено %d, %q", status, result)
+			}
+		})
+	}
+}
+
+func TestGetTask_MarksWaitingExpressions(t *testing.T) {
+	f := newFixture(t)
+	first := f.submit(`{"expression":"(2*3)+1"}`).Id
+	second := f.submit(`{"expression":"(2*3)+2"}`).Id
+	if _, ok := f.lease("a1", ""); !ok {
+		t.Fatal("Задача не выдана")
+	}
+	for _, id := range []string{first, second} {
+		if status, _ := f.status(id); status != 2 {
+			t.Errorf("Выражение %s: ожидался статус 2, получен %d", id, status)
+		}
+	}
+}
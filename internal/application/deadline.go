@@ -0,0 +1,48 @@
+package application
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// maxPriority — наибольший приоритет, который можно указать в POST /api/v1/calculate
+const maxPriority = 10
+
+// parseDeadline разбирает дедлайн выражения: момент времени в RFC 3339
+// или длительность от now в формате Go ("30s", "5m"). Пустая строка — без дедлайна.
+func parseDeadline(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	deadline, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		d, durErr := time.ParseDuration(s)
+		if durErr != nil {
+			return time.Time{}, errors.New("неверный дедлайн: ожидается время в RFC 3339 или длительность вроде 30s")
+		}
+		deadline = now.Add(d)
+	}
+	if !deadline.After(now) {
+		return time.Time{}, errors.New("дедлайн уже прошёл")
+	}
+	return deadline, nil
+}
+
+// expireDeadlines прерывает выражения, не успевшие к дедлайну. Вызывается под o.mu.
+func (o *Orchestrator) expireDeadlines(now time.Time) {
+	for exprID, meta := range o.exprs {
+		if meta.Deadline.IsZero() || now.Before(meta.Deadline) {
+			continue
+		}
+		log.Printf("Выражение %s не успело к дедлайну", exprID)
+		// снимаем с расписания так же, как при отмене: поздние результаты получат 410
+		userID := meta.UserID
+		o.cancelExpression(exprID)
+		if err := UpdateExpression(exprID, 6, nil); err != nil {
+			log.Printf("Ошибка обновления выражения %s: %v", exprID, err)
+		}
+		o.finish(exprID)
+		o.emit(Event{Type: EventExpressionTimeout, ExprID: exprID, UserID: userID, Status: getStatusName(6)})
+	}
+}
@@ -0,0 +1,50 @@
+package application
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeadline_ExpiredExpressionTimesOut(t *testing.T) {
+	f := newFixture(t)
+	id := f.submit(`{"expression":"(1+2)*(3+4)","deadline":"1h"}`).Id
+	leased, ok := f.lease("a1", "")
+	if !ok {
+		t.Fatal("Задача не выдана")
+	}
+	other := f.submit(`{"expression":"5+6"}`).Id
+
+	// до дедлайна ничего не меняется
+	f.o.mu.Lock()
+	f.o.expireDeadlines(time.Now())
+	f.o.mu.Unlock()
+	if status, _ := f.status(id); status != 2 {
+		t.Fatalf("Ожидался статус 2, получен %d", status)
+	}
+
+	f.o.mu.Lock()
+	f.o.expireDeadlines(time.Now().Add(2 * time.Hour))
+	queued := f.o.taskQueue.Len()
+	for _, task := range f.o.taskList {
+		if task.ExprID == id && !task.Cancelled {
+			t.Errorf("Задача %s осталась на расписании", task.ID)
+		}
+	}
+	f.o.mu.Unlock()
+	if status, _ := f.status(id); status != 6 {
+		t.Errorf("Ожидался статус 6, получен %d", status)
+	}
+	if queued != 1 {
+		t.Errorf("В очереди должна остаться задача другого выражения, осталось %d", queued)
+	}
+	if code := f.report("a1", compute(t, leased)); code != http.StatusGone {
+		t.Errorf("Ожидался код 410, получен %d", code)
+	}
+
+	// выражение без дедлайна считается дальше
+	f.drain()
+	if status, result := f.status(other); status != 3 || result != "11" {
+		t.Errorf("Получено %d, %q", status, result)
+	}
+}
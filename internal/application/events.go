@@ -0,0 +1,229 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// События о ходе вычисления рассылаются подписчикам через Server-Sent Events:
+// GET /api/v1/expressions/{id}/events — по одному выражению,
+// GET /api/v1/events — по всем выражениям пользователя.
+// EventSource в браузере не умеет ставить заголовки, поэтому JWT можно
+// передать и параметром access_token.
+
+const (
+	EventTaskEnqueued        = "task_enqueued"
+	EventTaskShared          = "task_shared"
+	EventTaskLeased          = "task_leased"
+	EventTaskRequeued        = "task_requeued"
+	EventTaskCompleted       = "task_completed"
+	EventTaskFailed          = "task_failed"
+	EventExpressionStatus    = "expression_status"
+	EventExpressionCompleted = "expression_completed"
+	EventExpressionFailed    = "expression_failed"
+	EventExpressionCancelled = "expression_cancelled"
+	EventExpressionTimeout   = "expression_timeout"
+	eventSubscriberBuffer    = 64
+	eventKeepAliveInterval   = 15 * time.Second
+)
+
+type Event struct {
+	Type      string      `json:"type"`
+	ExprID    string      `json:"expression_id"`
+	UserID    string      `json:"-"`
+	TaskID    string      `json:"task_id,omitempty"`
+	Operation string      `json:"operation,omitempty"`
+	Path      []int       `json:"path,omitempty"`
+	AgentID   string      `json:"agent_id,omitempty"`
+	Status    string      `json:"status,omitempty"`
+	Result    interface{} `json:"result,omitempty"`
+	Error     *TaskError  `json:"error,omitempty"`
+	Time      time.Time   `json:"time"`
+}
+
+// terminal сообщает, что после события выражение больше не изменится
+func (e Event) terminal() bool {
+	switch e.Type {
+	case EventExpressionCompleted, EventExpressionFailed, EventExpressionCancelled, EventExpressionTimeout:
+		return true
+	}
+	return false
+}
+
+type subscriber struct {
+	userID string
+	exprID string // пустой — все выражения пользователя
+	ch     chan Event
+}
+
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[*subscriber]struct{})}
+}
+
+func (h *eventHub) subscribe(userID, exprID string) *subscriber {
+	s := &subscriber{userID: userID, exprID: exprID, ch: make(chan Event, eventSubscriberBuffer)}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *eventHub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// publish не блокируется: медленный подписчик теряет события, но не задерживает
+// оркестратор, который вызывает publish под o.mu
+func (h *eventHub) publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.userID != e.UserID || (s.exprID != "" && s.exprID != e.ExprID) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+		}
+	}
+}
+
+// emit дополняет событие владельцем выражения и временем и рассылает его.
+// Вызывается под o.mu, пока выражение ещё не забыто.
+func (o *Orchestrator) emit(e Event) {
+	if e.UserID == "" {
+		meta, ok := o.exprs[e.ExprID]
+		if !ok {
+			return
+		}
+		e.UserID = meta.UserID
+	}
+	e.Time = time.Now()
+	o.events.publish(e)
+}
+
+func taskEvent(typ string, t *Task) Event {
+	return Event{Type: typ, ExprID: t.ExprID, UserID: t.UserID, TaskID: t.ID, Operation: t.Operation, Path: t.Path, AgentID: t.AgentID}
+}
+
+// streamUserID достаёт пользователя из заголовка Authorization или параметра access_token
+func streamUserID(r *http.Request) (string, bool) {
+	tokenStr := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		tokenStr = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if tokenStr == "" {
+		return "", false
+	}
+	claims, err := ParseJWT(tokenStr)
+	if err != nil {
+		return "", false
+	}
+	return claims.UserID, true
+}
+
+// expressionEventsHandler обрабатывает GET /api/v1/expressions/{id}/events
+func (o *Orchestrator) expressionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := streamUserID(r)
+	if !ok {
+		http.Error(w, "неверный токен", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 6 {
+		http.Error(w, "что-то пошло не так", http.StatusBadRequest)
+		return
+	}
+	exprID := parts[4]
+
+	// подписываемся до чтения статуса, чтобы не пропустить завершение между ними
+	sub := o.events.subscribe(userID, exprID)
+	defer o.events.unsubscribe(sub)
+
+	expr, code, msg := getUserExpression(exprID, userID)
+	if expr == nil {
+		http.Error(w, msg, code)
+		return
+	}
+
+	status := Event{Type: EventExpressionStatus, ExprID: exprID, Status: getStatusName(expr.StatusID), Time: time.Now()}
+	status.Result = expr.Result
+	if expr.Error != nil {
+		status.Error = &TaskError{Code: expr.Error.Code, Message: expr.Error.Message}
+	}
+	o.streamEvents(w, r, sub, &status, expr.StatusID != 1 && expr.StatusID != 2)
+}
+
+// userEventsHandler обрабатывает GET /api/v1/events
+func (o *Orchestrator) userEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := streamUserID(r)
+	if !ok {
+		http.Error(w, "неверный токен", http.StatusUnauthorized)
+		return
+	}
+	sub := o.events.subscribe(userID, "")
+	defer o.events.unsubscribe(sub)
+	o.streamEvents(w, r, sub, nil, false)
+}
+
+// streamEvents пишет события подписчика, пока клиент не отключится. Поток по
+// одному выражению закрывается после его завершения.
+func (o *Orchestrator) streamEvents(w http.ResponseWriter, r *http.Request, sub *subscriber, first *Event, done bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "стриминг не поддерживается", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if first != nil {
+		writeEvent(w, *first)
+	}
+	flusher.Flush()
+	if done {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e := <-sub.ch:
+			writeEvent(w, e)
+			flusher.Flush()
+			if sub.exprID != "" && e.terminal() {
+				return
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		// например, результат получился бесконечным
+		log.Printf("Ошибка кодирования события %s: %v", e.Type, err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+}
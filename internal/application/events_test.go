@@ -0,0 +1,129 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startStream запускает обработчик SSE в фоне. Тело ответа можно читать
+// только после того, как закроется возвращённый канал.
+func startStream(t *testing.T, f *fixture, handler http.HandlerFunc, req *http.Request) (*httptest.ResponseRecorder, chan struct{}) {
+	t.Helper()
+	f.o.events.mu.Lock()
+	before := len(f.o.events.subs)
+	f.o.events.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler(rec, req)
+	}()
+	// события до подписки в поток не попадут
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		f.o.events.mu.Lock()
+		n := len(f.o.events.subs)
+		f.o.events.mu.Unlock()
+		if n > before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Поток не подписался на события")
+		}
+	}
+	return rec, done
+}
+
+func waitStream(t *testing.T, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Поток не закрылся")
+	}
+}
+
+// streamTypes возвращает типы событий из тела ответа SSE
+func streamTypes(body string) []string {
+	var types []string
+	for _, line := range strings.Split(body, "\n") {
+		if typ, ok := strings.CutPrefix(line, "event: "); ok {
+			types = append(types, typ)
+		}
+	}
+	return types
+}
+
+func TestEvents_ExpressionStreamClosesWhenFinished(t *testing.T) {
+	f := newFixture(t)
+	id := f.submit(`{"expression":"(1+2)*(3+4)"}`).Id
+
+	// EventSource не умеет ставить заголовки, токен приходит параметром
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+id+"/events?access_token="+f.token, nil)
+	rec, done := startStream(t, f, f.o.expressionEventsHandler, req)
+	f.drain()
+	waitStream(t, done)
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Ожидался text/event-stream, получен %q", ct)
+	}
+	types := streamTypes(rec.Body.String())
+	if len(types) < 2 || types[0] != EventExpressionStatus || types[len(types)-1] != EventExpressionCompleted {
+		t.Fatalf("Получены события %v", types)
+	}
+	if !strings.Contains(rec.Body.String(), `"result":21`) {
+		t.Errorf("В потоке нет результата: %s", rec.Body)
+	}
+
+	// по завершённому выражению приходит только статус
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+id+"/events", nil)
+	req.Header.Set("Authorization", "Bearer "+f.token)
+	rec = httptest.NewRecorder()
+	f.o.expressionEventsHandler(rec, req)
+	if types := streamTypes(rec.Body.String()); len(types) != 1 || types[0] != EventExpressionStatus {
+		t.Errorf("Получены события %v", types)
+	}
+}
+
+func TestEvents_OtherUsersAreFilteredOut(t *testing.T) {
+	f := newFixture(t)
+	other := f.userToken("u2")
+	foreign := f.submit(`{"expression":"2+3"}`).Id
+
+	// чужое выражение и запрос без токена
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+foreign+"/events?access_token="+other, nil)
+	rec := httptest.NewRecorder()
+	f.o.expressionEventsHandler(rec, req)
+	if rec.Code == http.StatusOK {
+		t.Errorf("Чужое выражение отдано в поток: %s", rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	f.o.userEventsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/events", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Без токена: ожидался код 401, получен %d", rec.Code)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/events?access_token="+other, nil).WithContext(ctx)
+	rec, done := startStream(t, f, f.o.userEventsHandler, req)
+	f.drain()
+	own := serve(f.o.CreateHandler, http.MethodPost, "/api/v1/calculate", `{"expression":"4+5"}`, userHeader(other))
+	if own.Code != http.StatusCreated {
+		t.Fatalf("Ожидался код 201, получен %d", own.Code)
+	}
+	f.drain()
+	stop()
+	waitStream(t, done)
+
+	body := rec.Body.String()
+	if strings.Contains(body, foreign) {
+		t.Errorf("В поток попали события чужого выражения: %s", body)
+	}
+	if types := streamTypes(body); len(types) == 0 || types[len(types)-1] != EventExpressionCompleted {
+		t.Errorf("Получены события %v", types)
+	}
+}
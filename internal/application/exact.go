@@ -0,0 +1,67 @@
+package application
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+// Режим вычисления выражения. В точном режиме операнды и результаты задач
+// передаются строками (exact_args в задаче, exact в результате) и считаются
+// в big.Rat, а результат выражения сохраняется несократимой дробью или, если
+// задан digits, десятичной дробью с digits знаками после запятой.
+const (
+	ModeFloat = "float"
+	ModeExact = "exact"
+	maxDigits = 1000
+)
+
+// validateMode проверяет поля mode и digits запроса
+func validateMode(mode string, digits *int) error {
+	switch mode {
+	case "", ModeFloat:
+		if digits != nil {
+			return fmt.Errorf("digits можно задать только для mode %q", ModeExact)
+		}
+	case ModeExact:
+		if digits != nil && (*digits < 0 || *digits > maxDigits) {
+			return fmt.Errorf("digits должен быть от 0 до %d", maxDigits)
+		}
+	default:
+		return fmt.Errorf("неизвестный mode %q, ожидался %q или %q", mode, ModeFloat, ModeExact)
+	}
+	return nil
+}
+
+// exprKey — ключ кэша выражения. Точные выражения не совпадают с обычными,
+// а точные с разным digits — друг с другом, потому что результат записан по-разному.
+func exprKey(ast *ASTNode, mode string, digits int) string {
+	if mode != ModeExact {
+		return ast.String()
+	}
+	if digits >= 0 {
+		return fmt.Sprintf("exact.%d:%s", digits, ast.ExactString())
+	}
+	return "exact:" + ast.ExactString()
+}
+
+// taskKey — ключ задачи для общих подвыражений
+func taskKey(n *ASTNode, mode string) string {
+	if mode != ModeExact {
+		return n.String()
+	}
+	return "exact:" + n.ExactString()
+}
+
+// resultString записывает значение свернувшегося дерева для сохранения в БД
+func resultString(root *ASTNode, meta *exprMeta) string {
+	if meta == nil || meta.Mode != ModeExact {
+		return strconv.FormatFloat(root.Value, 'g', -1, 64)
+	}
+	res, err := calculation.FormatExact(root.Exact, meta.Digits)
+	if err != nil {
+		return strconv.FormatFloat(root.Value, 'g', -1, 64)
+	}
+	return res
+}
@@ -0,0 +1,162 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+// Тесты обработчиков живут в пакете: обработчики агентского протокола,
+// токены агентов и часы аренды не экспортируются, а поднимать ради них
+// сервер с настоящими таймерами слишком медленно.
+
+// fixture — оркестратор на чистой БД во временном каталоге, агент a1 и
+// токен пользователя u1
+type fixture struct {
+	t     *testing.T
+	o     *Orchestrator
+	token string
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	if err := InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("Ошибка инициализации БД: %v", err)
+	}
+	t.Cleanup(func() { DB.Close() })
+	jwtSecret = []byte("test-secret")
+	f := &fixture{t: t, o: NewOrchestrator()}
+	// выполняется раньше DB.Close: фоновые горутины ещё читают БД
+	t.Cleanup(func() { f.o.Close() })
+	f.token = f.userToken("u1")
+	f.addAgent("a1")
+	return f
+}
+
+// restart создаёт новый оркестратор на той же БД, как после перезапуска.
+// Агент a1 регистрируется заново с тем же ID.
+func (f *fixture) restart() {
+	f.o.Close()
+	f.o = NewOrchestrator()
+	f.addAgent("a1")
+}
+
+// addAgent регистрирует агента в обход POST /internal/agents/register
+func (f *fixture) addAgent(id string) {
+	f.o.mu.Lock()
+	defer f.o.mu.Unlock()
+	f.o.agents[id] = &AgentInfo{ID: id, RegisteredAt: time.Now(), LastSeen: time.Now(), token: id + "-token"}
+}
+
+func (f *fixture) userToken(userID string) string {
+	f.t.Helper()
+	token, err := GenerateJWT(userID)
+	if err != nil {
+		f.t.Fatalf("Ошибка создания токена: %v", err)
+	}
+	return token
+}
+
+// submit отправляет выражение от u1 и возвращает ответ POST /api/v1/calculate
+func (f *fixture) submit(body string) Id {
+	f.t.Helper()
+	rec := serve(f.o.CreateHandler, http.MethodPost, "/api/v1/calculate", body, userHeader(f.token))
+	if rec.Code != http.StatusCreated {
+		f.t.Fatalf("Ожидался код 201, получен %d: %s", rec.Code, rec.Body)
+	}
+	var resp Id
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		f.t.Fatalf("Неверный ответ: %v", err)
+	}
+	return resp
+}
+
+// cancel отменяет выражение от имени владельца токена
+func (f *fixture) cancel(exprID, token string) *httptest.ResponseRecorder {
+	return serve(f.o.cancelExpressionHandler, http.MethodDelete, "/api/v1/expressions/"+exprID, "", userHeader(token))
+}
+
+// lease берёт одну задачу; ok == false, если задач нет
+func (f *fixture) lease(agentID, query string) (*Task, bool) {
+	f.t.Helper()
+	rec := serve(f.o.getTaskHandler, http.MethodGet, "/internal/task?"+query, "", agentHeader(agentID))
+	if rec.Code == http.StatusNotFound {
+		return nil, false
+	}
+	if rec.Code != http.StatusOK {
+		f.t.Fatalf("Ожидался код 200, получен %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Task *Task `json:"task"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		f.t.Fatalf("Неверный ответ: %v", err)
+	}
+	return resp.Task, true
+}
+
+// report отправляет результат задачи и возвращает код ответа
+func (f *fixture) report(agentID string, res TaskResult) int {
+	body, _ := json.Marshal(res)
+	return serve(f.o.postTaskHandler, http.MethodPost, "/internal/task", string(body), agentHeader(agentID)).Code
+}
+
+// drain выполняет задачи агентом a1, пока очередь не опустеет
+func (f *fixture) drain() {
+	f.t.Helper()
+	for {
+		task, ok := f.lease("a1", "")
+		if !ok {
+			return
+		}
+		if code := f.report("a1", compute(f.t, task)); code != http.StatusOK {
+			f.t.Fatalf("Результат задачи %s не принят: %d", task.ID, code)
+		}
+	}
+}
+
+// status возвращает статус и результат выражения из БД
+func (f *fixture) status(exprID string) (int, string) {
+	f.t.Helper()
+	expr, err := GetExpressionByID(exprID)
+	if err != nil {
+		f.t.Fatalf("Выражение %s не найдено: %v", exprID, err)
+	}
+	return expr.StatusID, expr.RawResult.String
+}
+
+func serve(handler http.HandlerFunc, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func userHeader(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func agentHeader(agentID string) http.Header {
+	h := userHeader(agentID + "-token")
+	h.Set(agentHeaderID, agentID)
+	return h
+}
+
+// compute считает задачу так же, как агент
+func compute(t *testing.T, task *Task) TaskResult {
+	t.Helper()
+	res, err := calculation.Compute(task.Operation, float64(task.Arg1), float64(task.Arg2))
+	if err != nil {
+		t.Fatalf("Ошибка вычисления задачи %+v: %v", task, err)
+	}
+	return TaskResult{TaskID: task.ID, LeaseID: task.LeaseID, Result: Number(res)}
+}
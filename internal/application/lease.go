@@ -0,0 +1,65 @@
+package application
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Выданная агенту задача арендуется: если результат не пришёл до дедлайна
+// (время операции + запас), задача возвращается в очередь и выдаётся заново.
+
+const leaseCheckInterval = time.Second
+
+func leaseGrace() time.Duration {
+	ms, err := strconv.Atoi(os.Getenv("TASK_LEASE_GRACE_MS"))
+	if err != nil || ms < 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+func (o *Orchestrator) leaseTask(task *Task, agentID string) {
+	leaseID, _ := generateRandomID(8)
+	task.LeaseID = leaseID
+	task.AgentID = agentID
+	task.LeaseDeadline = time.Now().Add(time.Duration(task.OperationTime)*time.Millisecond + leaseGrace())
+}
+
+func (o *Orchestrator) requeueExpiredLeases(now time.Time) {
+	list := o.taskList[:0]
+	for _, task := range o.taskList {
+		if task.LeaseID == "" || now.Before(task.LeaseDeadline) {
+			list = append(list, task)
+			continue
+		}
+		if task.Cancelled {
+			// результат отменённого выражения уже не нужен
+			continue
+		}
+		log.Printf("Аренда задачи %s истекла, возвращаем в очередь", task.ID)
+		task.LeaseID = ""
+		task.LeaseDeadline = time.Time{}
+		task.AgentID = ""
+		o.emit(taskEvent(EventTaskRequeued, task))
+		list = append(list, task)
+		// брошенная задача старше всех в очереди, поэтому ставим её в начало
+		o.taskQueue.PushFront(task)
+		o.persistExpression(task.ExprID)
+		o.notifyTasks()
+	}
+	o.taskList = list
+}
+
+func (o *Orchestrator) watchLeases() {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		o.mu.Lock()
+		o.requeueExpiredLeases(now)
+		o.expireDeadlines(now)
+		o.forgetSilentAgents(now)
+		o.mu.Unlock()
+	}
+}
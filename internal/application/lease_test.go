@@ -0,0 +1,58 @@
+package application
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLease_ExpiredTaskIsRequeued(t *testing.T) {
+	f := newFixture(t)
+	f.addAgent("a2")
+	id := f.submit(`{"expression":"2+3"}`).Id
+
+	first, ok := f.lease("a1", "")
+	if !ok {
+		t.Fatal("Задача не выдана")
+	}
+	if first.LeaseID == "" || !first.LeaseDeadline.After(time.Now()) {
+		t.Fatalf("Задача выдана без аренды: %+v", first)
+	}
+	if _, ok := f.lease("a2", ""); ok {
+		t.Fatal("Арендованная задача выдана повторно")
+	}
+
+	// пока аренда не истекла, задача остаётся у агента
+	f.o.mu.Lock()
+	f.o.requeueExpiredLeases(time.Now())
+	f.o.mu.Unlock()
+	if _, ok := f.lease("a2", ""); ok {
+		t.Fatal("Задача вернулась в очередь до истечения аренды")
+	}
+
+	f.o.mu.Lock()
+	f.o.requeueExpiredLeases(first.LeaseDeadline.Add(time.Millisecond))
+	f.o.mu.Unlock()
+	second, ok := f.lease("a2", "")
+	if !ok {
+		t.Fatal("Задача с истёкшей арендой не вернулась в очередь")
+	}
+	if second.ID != first.ID || second.LeaseID == first.LeaseID {
+		t.Fatalf("Ожидалась та же задача с новой арендой: %+v, %+v", first, second)
+	}
+
+	// опоздавший агент со старой арендой
+	if code := f.report("a1", compute(t, first)); code != http.StatusConflict {
+		t.Errorf("Ожидался код 409, получен %d", code)
+	}
+	// чужая аренда
+	if code := f.report("a1", compute(t, second)); code != http.StatusForbidden {
+		t.Errorf("Ожидался код 403, получен %d", code)
+	}
+	if code := f.report("a2", compute(t, second)); code != http.StatusOK {
+		t.Fatalf("Ожидался код 200, получен %d", code)
+	}
+	if status, result := f.status(id); status != 3 || result != "5" {
+		t.Errorf("Получено %d, %q", status, result)
+	}
+}
@@ -0,0 +1,67 @@
+package application
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Long polling: GET /internal/task?wait=30s ждёт появления задачи вместо
+// мгновенного 404. Ожидающих агентов будит notifyTasks.
+
+const maxTaskWait = time.Minute
+
+// notifyTasks будит всех, кто ждёт задач. Вызывается под o.mu после
+// постановки задач в очередь.
+func (o *Orchestrator) notifyTasks() {
+	close(o.taskSignal)
+	o.taskSignal = make(chan struct{})
+}
+
+// waitForTasks ждёт, пока очередь не станет непустой, до дедлайна или отмены
+// запроса. Вызывается под o.mu и возвращает управление тоже под o.mu.
+func (o *Orchestrator) waitForTasks(ctx context.Context, deadline time.Time) bool {
+	for o.taskQueue.Len() == 0 {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		signal := o.taskSignal
+		o.mu.Unlock()
+		timer := time.NewTimer(remaining)
+		select {
+		case <-signal:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		o.mu.Lock()
+		if ctx.Err() != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseWait разбирает параметр wait: длительность в формате Go ("30s", "500ms")
+// или целое число секунд
+func parseWait(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		sec, err := strconv.Atoi(s)
+		if err != nil {
+			return 0
+		}
+		d = time.Duration(sec) * time.Second
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > maxTaskWait {
+		return maxTaskWait
+	}
+	return d
+}
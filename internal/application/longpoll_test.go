@@ -0,0 +1,40 @@
+package application
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetTask_LongPollWakesUp(t *testing.T) {
+	f := newFixture(t)
+
+	start := time.Now()
+	leased := make(chan int)
+	go func() {
+		leased <- serve(f.o.getTaskHandler, http.MethodGet, "/internal/task?wait=5s", "", agentHeader("a1")).Code
+	}()
+	time.Sleep(100 * time.Millisecond)
+	f.submit(`{"expression":"2+3"}`)
+
+	select {
+	case code := <-leased:
+		if code != http.StatusOK {
+			t.Fatalf("Ожидающий агент не получил задачу: %d", code)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Агент проснулся через %v", elapsed)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Запрос не завершился")
+	}
+
+	// без задач ожидание заканчивается по wait
+	start = time.Now()
+	if _, ok := f.lease("a1", "wait=200ms"); ok {
+		t.Fatal("Очередь должна быть пуста")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Ответ пришёл через %v, раньше wait", elapsed)
+	}
+}
@@ -0,0 +1,20 @@
+package application
+
+import (
+	"os"
+	"strconv"
+)
+
+// Перед раздачей задач дерево упрощается calculation.Optimize: x*1, 0*x,
+// (a+b)-(a+b) и т.п. не уходят агентам. OPTIMIZE_AST=false отключает
+// оптимизатор, поле optimize в запросе переопределяет настройку для одного
+// выражения. Применённые упрощения видны в GET /api/v1/expressions/{id}.
+
+// optimizeEnabled решает, упрощать ли выражение; по умолчанию — да
+func optimizeEnabled(override *bool) bool {
+	if override != nil {
+		return *override
+	}
+	on, err := strconv.ParseBool(os.Getenv("OPTIMIZE_AST"))
+	return err != nil || on
+}
@@ -0,0 +1,19 @@
+package application
+
+import "testing"
+
+func TestCreate_ExactModeKeepsPowers(t *testing.T) {
+	f := newFixture(t)
+	float := f.submit(`{"expression":"(1^10000000)*0"}`).Id
+	if status, result := f.status(float); status != 3 || result != "0" {
+		t.Errorf("Получено %d, %q", status, result)
+	}
+	exact := f.submit(`{"expression":"(1^10000000)*0","mode":"exact"}`).Id
+	task, ok := f.lease("a1", "")
+	if !ok || task.Operation != "^" {
+		t.Fatalf("Ожидалась задача возведения в степень, получено %+v", task)
+	}
+	if status, _ := f.status(exact); status != 2 {
+		t.Errorf("Ожидался статус 2, получен %d", status)
+	}
+}
@@ -1,18 +1,24 @@
 package application
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/joho/godotenv"
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
 )
 
 type Application struct {
@@ -28,31 +34,115 @@ type Request struct {
 
 type Id struct {
 	Id string `json:"id"`
+	// Cached — результат взят у уже вычисленного такого же выражения
+	Cached bool `json:"cached,omitempty"`
+	// Shared — такое же выражение уже считается, результат придёт от него
+	Shared bool `json:"shared,omitempty"`
 }
 
 type Orchestrator struct {
-	taskList    []Task
-	taskQueue   []*Task
-	mu          sync.Mutex
-	astStore    map[string]*ASTNode
+	taskList   []*Task
+	taskQueue  Scheduler
+	mu         sync.Mutex
+	astStore   map[string]*ASTNode
+	exprs      map[string]*exprMeta
+	inflight   map[string]string // каноническая запись → считающееся выражение
+	followers  map[string][]*follower
+	shared     map[string]*Task // ключ подвыражения → основная задача
+	agents     map[string]*AgentInfo
+	taskSignal chan struct{}
+	events     *eventHub
+	webhooks   *WebhookSender
+	// выражение → ссылающиеся на него выражения, ждущие его результата
+	dependents map[string][]string
+	// фоновые горутины (webhook, продолжение зависимых выражений), которые
+	// Close дожидается перед закрытием БД
+	background sync.WaitGroup
 }
 
 func NewOrchestrator() *Orchestrator {
-	return &Orchestrator{
-		taskList:    []Task{},
-		taskQueue:   []*Task{},
-		astStore:    make(map[string]*ASTNode),
-	}
+	o := &Orchestrator{
+		taskList:  []*Task{},
+		taskQueue: schedulerFromEnv(),
+		astStore:  make(map[string]*ASTNode),
+		exprs:     make(map[string]*exprMeta),
+		inflight:  make(map[string]string),
+		followers: make(map[string][]*follower),
+		shared:    make(map[string]*Task),
+		agents:    make(map[string]*AgentInfo),
+		// закрывается и пересоздаётся при появлении задач, см. notifyTasks
+		taskSignal: make(chan struct{}),
+		events:     newEventHub(),
+		webhooks:   newWebhookSender(),
+		dependents: make(map[string][]string),
+	}
+	o.restore()
+	return o
+}
+
+// exprMeta — то, что планировщику нужно знать о незавершённом выражении
+type exprMeta struct {
+	UserID     string
+	Priority   int
+	Deadline   time.Time
+	Normalized string
+	Mode       string
+	// Digits — знаков после запятой в точном результате, -1 — дробью
+	Digits int
+}
+
+// TaskError — ошибка вычисления, которую агент присылает вместо результата
+type TaskError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// maxTaskBatch ограничивает число задач, выдаваемых агенту за один запрос
+const maxTaskBatch = 100
+
+// TaskResult — результат задачи, который агент отправляет в POST /internal/task
+type TaskResult struct {
+	TaskID  string     `json:"task_id"`
+	LeaseID string     `json:"lease_id"`
+	Result  Number     `json:"result"`
+	Exact   string     `json:"exact,omitempty"`
+	Error   *TaskError `json:"error,omitempty"`
+}
+
+// ResultStatus — ответ оркестратора на один результат из пакета
+type ResultStatus struct {
+	TaskID string `json:"task_id"`
+	Status string `json:"status"`
+	Code   int    `json:"code"`
+	Error  string `json:"error,omitempty"`
 }
 
 type Task struct {
-	ID            string   `json:"id"`
-	ExprID        string   `json:"-"`
-	Arg1          float64  `json:"arg1"`
-	Arg2          float64  `json:"arg2"`
-	Operation     string   `json:"operation"`
-	OperationTime int      `json:"operation_time"`
-	Node          *ASTNode `json:"-"`
+	ID            string    `json:"id"`
+	ExprID        string    `json:"-"`
+	UserID        string    `json:"-"`
+	Priority      int       `json:"-"`
+	QueuedAt      time.Time `json:"-"`
+	Arg1          Number    `json:"arg1"`
+	Arg2          Number    `json:"arg2"`
+	Args          []Number  `json:"args,omitempty"`
+	Operation     string    `json:"operation"`
+	OperationTime int       `json:"operation_time"`
+	LeaseID       string    `json:"lease_id,omitempty"`
+	LeaseDeadline time.Time `json:"lease_deadline"`
+	AgentID       string    `json:"-"`
+	Node          *ASTNode  `json:"-"`
+	Path          []int     `json:"-"`
+	// ExactArgs — операнды точного режима; если заданы, агент считает по ним
+	ExactArgs []string `json:"exact_args,omitempty"`
+	// Cancelled — выражение отменено, пока задача была в аренде
+	Cancelled bool `json:"-"`
+	// Key — каноническая запись узла; задачи с одинаковым ключом считаются один раз
+	Key string `json:"-"`
+	// SharedWith — основная задача, результата которой ждёт эта
+	SharedWith *Task `json:"-"`
+	// Waiters — задачи, ждущие результата этой
+	Waiters []*Task `json:"-"`
 }
 
 func init() {
@@ -65,40 +155,16 @@ func init() {
 	}
 }
 
+// Valid сообщает, разбирается ли выражение. Правила грамматики живут только
+// в парсере, поэтому Valid и ParseAST не могут разойтись.
 func Valid(e string) bool {
-	valid_chars := "1234567890+-*/()"
-	// чек на посторонние символы и равное кол-во открывающихся и закрывающихся скобок
-	c1 := 0
-	c2 := 0
-	for i := range len(e) {
-		if !strings.ContainsRune(valid_chars, rune(e[i])) {
-			log.Printf("Невалидные символы")
-			return false
-		}
-		if string(e[i]) == "(" {
-			c1 += 1
-		} else if string(e[i]) == ")" {
-			c2 += 1
-		}
-	}
-	if c1 != c2 {
-		log.Printf("Неравное кол-во скобок")
+	if _, err := ParseAST(e); err != nil {
+		log.Printf("Невалидное выражение: %v", err)
 		return false
 	}
-
-	// чек на неправильную расстановку
-	for i := range len(e) - 1 {
-		if (string(e[i]) == "+" || string(e[i]) == "-" || string(e[i]) == "/" || string(e[i]) == "*" || string(e[i]) == "(" || string(e[i]) == ")") && (string(e[i+1]) == "+" || string(e[i+1]) == "*" || string(e[i+1]) == "/" || string(e[i+1]) == "-" || string(e[i+1]) == ")") {
-			log.Printf("Невалидные знаки")
-			return false
-		}
-	}
-	//чек ласт символ
-	if string(e[len(e)-1]) == "+" || string(e[len(e)-1]) == "-" || string(e[len(e)-1]) == "*" || string(e[len(e)-1]) == "/" || string(e[len(e)-1]) == "(" {
-		log.Printf("Неверный последний символ")
-	}
 	return true
 }
+
 func (o *Orchestrator) CreateHandler(w http.ResponseWriter, r *http.Request) {
 	auth := r.Header.Get("Authorization")
 	if !strings.HasPrefix(auth, "Bearer ") {
@@ -114,7 +180,15 @@ func (o *Orchestrator) CreateHandler(w http.ResponseWriter, r *http.Request) {
 	userID := claims.UserID
 
 	var req struct {
-		Expression string `json:"expression"`
+		Expression string         `json:"expression"`
+		Priority   int            `json:"priority"`
+		Deadline   string         `json:"deadline"`
+		WebhookURL string         `json:"webhook_url"`
+		NoCache    bool           `json:"no_cache"`
+		Optimize   *bool          `json:"optimize"`
+		Mode       string         `json:"mode"`
+		Digits     *int           `json:"digits"`
+		Format     *FormatOptions `json:"format"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "неверный JSON", http.StatusBadRequest)
@@ -122,48 +196,225 @@ func (o *Orchestrator) CreateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	// Очистка и валидация выражения
-	expr := strings.ReplaceAll(req.Expression, " ", "")
-	if !Valid(expr) {
-		http.Error(w, "невалидное выражение", http.StatusUnprocessableEntity)
+	if req.Priority < 0 || req.Priority > maxPriority {
+		http.Error(w, fmt.Sprintf("приоритет должен быть от 0 до %d", maxPriority), http.StatusBadRequest)
+		return
+	}
+	deadline, err := parseDeadline(req.Deadline, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateMode(req.Mode, req.Digits); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Format != nil {
+		if err := req.Format.Validate(); err != nil {
+			http.Error(w, "неверный формат: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	mode, digits := ModeFloat, -1
+	if req.Mode == ModeExact {
+		mode = ModeExact
+		if req.Digits != nil {
+			digits = *req.Digits
+		}
+	}
+	if req.WebhookURL != "" {
+		if err := validateWebhookURL(req.WebhookURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if os.Getenv("WEBHOOK_SECRET") == "" {
+			log.Printf("WEBHOOK_SECRET не задан, уведомление на %s уйдёт без подписи", req.WebhookURL)
+		}
+	}
+
+	// Валидация и очистка выражения
+	vars, err := userVariables(userID)
+	if err != nil {
+		http.Error(w, "ошибка сервера", http.StatusInternalServerError)
 		return
 	}
+	ast, err := calculation.ParseASTWith(req.Expression, vars)
+	if err != nil {
+		writeSyntaxError(w, err)
+		return
+	}
+	used := calculation.UsedVariables(ast)
+	refs := make(map[string]string)
+	for _, ref := range calculation.References(ast) {
+		depID := ref
+		if ref == calculation.RefLast {
+			depID, err = GetLastExpressionID(userID)
+			if err != nil {
+				http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+				return
+			}
+			if depID == "" {
+				writeSyntaxError(w, errors.New("ans: нет ни одного вычисленного или считающегося выражения"))
+				return
+			}
+			calculation.ReplaceReference(ast, ref, depID)
+		}
+		dep, code, msg := getUserExpression(depID, userID)
+		if dep == nil {
+			http.Error(w, fmt.Sprintf("%s: %s", msg, depID), code)
+			return
+		}
+		switch dep.StatusID {
+		case 4, 5, 6:
+			writeSyntaxError(w, fmt.Errorf("выражение %s завершилось со статусом %s", depID, getStatusName(dep.StatusID)))
+			return
+		}
+		if ref == calculation.RefLast {
+			refs[ref] = depID
+		} else {
+			refs["$"+ref] = depID
+		}
+	}
+	if mode == ModeExact {
+		if err := calculation.CheckExact(ast); err != nil {
+			writeSyntaxError(w, err)
+			return
+		}
+	}
+	expr := strings.ReplaceAll(req.Expression, " ", "")
+	normalized := exprKey(ast, mode, digits)
+	var rewrites []Rewrite
+	if optimizeEnabled(req.Optimize) {
+		if mode == ModeExact {
+			ast, rewrites = calculation.OptimizeExact(ast)
+		} else {
+			ast, rewrites = calculation.Optimize(ast)
+		}
+	}
 	exprID, _ := generateRandomID(8)
 
 	// Сохранение в БД
-	if err := InsertExpresions(exprID, userID, expr, 1); err != nil {
+	record := &NewExpression{
+		ID:         exprID,
+		UserID:     userID,
+		Expression: expr,
+		Normalized: normalized,
+		Priority:   req.Priority,
+		Deadline:   deadline,
+		WebhookURL: req.WebhookURL,
+		Mode:       mode,
+		Digits:     digits,
+		Format:     req.Format,
+		Rewrites:   rewrites,
+		Variables:  used,
+		Refs:       refs,
+	}
+	if err := InsertExpresions(record, 1); err != nil {
 		http.Error(w, "ошибка сервера", http.StatusInternalServerError)
 		return
 	}
-	ast, _ := ParseAST(req.Expression)
-	o.astStore[exprID] = ast
-	o.ProcessAST(exprID, ast)
 
+	resp := Id{Id: exprID}
+	o.mu.Lock()
+	o.exprs[exprID] = &exprMeta{UserID: userID, Priority: req.Priority, Deadline: deadline, Normalized: normalized, Mode: mode, Digits: digits}
+	if !req.NoCache {
+		resp.Cached, err = o.reuseCached(exprID, normalized)
+		if err == nil && !resp.Cached {
+			resp.Shared, err = o.follow(exprID, normalized, ast)
+		}
+	}
+	if err == nil && !resp.Cached && !resp.Shared {
+		o.astStore[exprID] = ast
+		o.lead(exprID, normalized)
+		if o.linkReferences(exprID) {
+			err = o.advance(exprID)
+		}
+	}
+	o.mu.Unlock()
+	if err != nil {
+		http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(Id{Id: exprID})
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeSyntaxError отвечает 422 с описанием ошибки, которое клиент может показать пользователю
+func writeSyntaxError(w http.ResponseWriter, err error) {
+	resp := map[string]interface{}{
+		"error":   "невалидное выражение",
+		"message": err.Error(),
+	}
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		resp["message"] = syntaxErr.Message
+		resp["position"] = syntaxErr.Position
+		resp["expected"] = syntaxErr.Expected
+		resp["found"] = syntaxErr.Found
+		resp["snippet"] = syntaxErr.Snippet()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(resp)
 }
 
-func (o *Orchestrator) getTaskHandler(w http.ResponseWriter, _ *http.Request) {
+func (o *Orchestrator) getTaskHandler(w http.ResponseWriter, r *http.Request) {
+	// без max отвечаем одной задачей в прежнем формате {"task": ...}
+	maxParam := r.URL.Query().Get("max")
+	limit := 1
+	if maxParam != "" {
+		n, err := strconv.Atoi(maxParam)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error":"неверный параметр max"}`, http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxTaskBatch)
+	}
+
 	o.mu.Lock()
 	defer o.mu.Unlock()
+	agent := o.authenticateAgent(r)
+	if agent == nil {
+		http.Error(w, `{"error":"агент не авторизован"}`, http.StatusUnauthorized)
+		return
+	}
 
-	if len(o.taskQueue) == 0 {
+	deadline := time.Now().Add(parseWait(r.URL.Query().Get("wait")))
+	if !o.waitForTasks(r.Context(), deadline) {
 		http.Error(w, `{"error":"таски закончились"}`, http.StatusNotFound)
 		return
 	}
 
-	task := o.dequeueTask()
-	updateGetExpressionStatus(task.ExprID, 2)
+	tasks := make([]*Task, 0, limit)
+	leased := make(map[string]bool)
+	for len(tasks) < limit && o.taskQueue.Len() > 0 {
+		task := o.dequeueTask()
+		o.leaseTask(task, agent.ID)
+		o.emit(taskEvent(EventTaskLeased, task))
+		tasks = append(tasks, task)
+		// общую задачу считают и для выражений, которые ждут её результата
+		for _, t := range append([]*Task{task}, task.Waiters...) {
+			if !leased[t.ExprID] {
+				leased[t.ExprID] = true
+				updateGetExpressionStatus(t.ExprID, 2)
+			}
+		}
+	}
+	for exprID := range leased {
+		o.persistExpression(exprID)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"task": task})
+	if maxParam == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"task": tasks[0]})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"tasks": tasks})
 }
 
 func (o *Orchestrator) dequeueTask() *Task {
-	task := o.taskQueue[0]
-	o.taskQueue = o.taskQueue[1:]
-	return task
+	return o.taskQueue.Pop()
 }
 
 func (o *Orchestrator) getAllExpressionsHandler(w http.ResponseWriter, r *http.Request) {
@@ -185,6 +436,10 @@ func (o *Orchestrator) getAllExpressionsHandler(w http.ResponseWriter, r *http.R
 		http.Error(w, "нет такого выражения", http.StatusInternalServerError)
 		return
 	}
+	format := userFormat(userID)
+	for i := range expressions {
+		expressions[i].render(format)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(expressions)
@@ -211,24 +466,22 @@ func (o *Orchestrator) getExpressionByIDHandler(w http.ResponseWriter, r *http.R
 	}
 	exprID := parts[4]
 
-	expr, err := GetExpressionByID(exprID)
-	if err != nil {
-		http.Error(w, "выражение не существует", http.StatusNotFound)
-		return
-	}
-	if expr.UserID != userID {
-		http.Error(w, "отказано в доступе", http.StatusForbidden)
+	expr, code, msg := getUserExpression(exprID, userID)
+	if expr == nil {
+		http.Error(w, msg, code)
 		return
 	}
+	expr.render(userFormat(userID))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(expr)
 }
 
 func (o *Orchestrator) postTaskHandler(w http.ResponseWriter, r *http.Request) {
+	// одиночный результат приходит полями верхнего уровня, пакет — в results
 	var req struct {
-		TaskID string  `json:"task_id"`
-		Result float64 `json:"result"`
+		TaskResult
+		Results []TaskResult `json:"results"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, `{"error":"невалидный json"}`, http.StatusBadRequest)
@@ -236,74 +489,289 @@ func (o *Orchestrator) postTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	r.Body.Close()
 	o.mu.Lock()
-	task, idx := o.findTaskByID(req.TaskID)
-	if task == nil {
+	agent := o.authenticateAgent(r)
+	if agent == nil {
 		o.mu.Unlock()
-		http.Error(w, `{"error":"таск не найден"}`, http.StatusNotFound)
+		http.Error(w, `{"error":"агент не авторизован"}`, http.StatusUnauthorized)
 		return
 	}
-	o.taskList = append(o.taskList[:idx], o.taskList[idx+1:]...)
-	o.updateASTNode(task.Node, req.Result)
-
-	root := o.astStore[task.ExprID]
 
-	if root.IsLeaf {
-		resultStr := fmt.Sprintf("%f", root.Value)
-		if err := UpdateExpressionResult(task.ExprID, resultStr, 3); err != nil {
-			o.mu.Unlock()
-			http.Error(w, `{"error":"db update failed"}`, http.StatusInternalServerError)
+	if req.Results == nil {
+		code, msg := o.applyResult(agent, req.TaskResult)
+		o.mu.Unlock()
+		if code != http.StatusOK {
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, msg), code)
 			return
 		}
-	} else {
-		o.ProcessAST(task.ExprID, root)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
 	}
 
+	statuses := make([]ResultStatus, 0, len(req.Results))
+	for _, res := range req.Results {
+		code, msg := o.applyResult(agent, res)
+		st := ResultStatus{TaskID: res.TaskID, Code: code, Status: "ok"}
+		if code != http.StatusOK {
+			st.Status = "error"
+			st.Error = msg
+		}
+		statuses = append(statuses, st)
+	}
 	o.mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": statuses})
+}
+
+// applyResult принимает результат одной задачи от агента. Возвращает
+// HTTP-код и сообщение об ошибке, если результат не принят. Вызывается под o.mu.
+func (o *Orchestrator) applyResult(agent *AgentInfo, res TaskResult) (int, string) {
+	task, idx := o.findTaskByID(res.TaskID)
+	if task == nil {
+		return http.StatusNotFound, "таск не найден"
+	}
+	if task.Cancelled {
+		o.taskList = append(o.taskList[:idx], o.taskList[idx+1:]...)
+		return http.StatusGone, "выражение снято с вычисления"
+	}
+	if task.LeaseID == "" || task.LeaseID != res.LeaseID {
+		// аренда истекла: задача вернулась в очередь или уже выдана другому агенту
+		return http.StatusConflict, "аренда задачи истекла"
+	}
+	if task.AgentID != agent.ID {
+		return http.StatusForbidden, "задача выдана другому агенту"
+	}
+	o.taskList = append(o.taskList[:idx], o.taskList[idx+1:]...)
+	agent.Completed++
+	// результат общий для всех задач с тем же подвыражением
+	tasks := append([]*Task{task}, o.settle(task)...)
+
+	if res.Error != nil {
+		log.Printf("Задача %s выражения %s завершилась ошибкой %s: %s", task.ID, task.ExprID, res.Error.Code, res.Error.Message)
+		failed := make(map[string]bool)
+		var failErr error
+		for _, t := range tasks {
+			e := taskEvent(EventTaskFailed, t)
+			e.Error = res.Error
+			o.emit(e)
+			if failed[t.ExprID] {
+				continue
+			}
+			failed[t.ExprID] = true
+			if err := o.failExpression(t.ExprID, res.Error.Code, res.Error.Message); err != nil && failErr == nil {
+				failErr = err
+			}
+		}
+		if failErr != nil {
+			return http.StatusInternalServerError, "db update failed"
+		}
+		return http.StatusOK, ""
+	}
+
+	var exprIDs []string
+	seen := make(map[string]bool)
+	for _, t := range tasks {
+		e := taskEvent(EventTaskCompleted, t)
+		e.Result = numberValue(float64(res.Result), FormatOptions{})
+		if res.Exact != "" {
+			e.Result = resultValue(res.Exact, ModeExact, FormatOptions{})
+		}
+		o.emit(e)
+		o.updateASTNode(t.Node, float64(res.Result), res.Exact)
+		if !seen[t.ExprID] {
+			seen[t.ExprID] = true
+			exprIDs = append(exprIDs, t.ExprID)
+		}
+	}
+	var advanceErr error
+	for _, exprID := range exprIDs {
+		if err := o.advance(exprID); err != nil && advanceErr == nil {
+			advanceErr = err
+		}
+	}
+	if advanceErr != nil {
+		return http.StatusInternalServerError, "db update failed"
+	}
+	return http.StatusOK, ""
+}
+
+// failExpression снимает с расписания все задачи выражения и сохраняет причину ошибки
+func (o *Orchestrator) failExpression(exprID, code, message string) error {
+	o.dropTasks(exprID)
+	err := UpdateExpressionError(exprID, code, message)
+	o.finish(exprID)
+	o.emit(Event{Type: EventExpressionFailed, ExprID: exprID, Status: getStatusName(4), Error: &TaskError{Code: code, Message: message}})
+	o.forgetExpression(exprID)
+	// у одинаковых выражений та же ошибка
+	for _, f := range o.takeFollowers(exprID) {
+		if err := o.failExpression(f.exprID, code, message); err != nil {
+			log.Printf("Ошибка обновления выражения %s: %v", f.exprID, err)
+		}
+	}
+	return err
+}
 
+// advance ставит в очередь узлы, готовые к вычислению. Если дерево уже
+// свернулось до одного числа, сохраняет результат выражения.
+func (o *Orchestrator) advance(exprID string) error {
+	root := o.astStore[exprID]
+	o.ProcessAST(exprID, root)
+	if root.IsLeaf {
+		return o.complete(exprID, resultString(root, o.exprs[exprID]))
+	}
+	o.persistExpression(exprID)
+	return nil
 }
 
-func (o *Orchestrator) updateASTNode(node *ASTNode, result float64) {
+// complete сохраняет результат выражения и передаёт его ведомым
+func (o *Orchestrator) complete(exprID, resultStr string) error {
+	if err := UpdateExpressionResult(exprID, resultStr, 3); err != nil {
+		return err
+	}
+	o.finish(exprID)
+	completed := Event{Type: EventExpressionCompleted, ExprID: exprID, Status: getStatusName(3)}
+	mode := ModeFloat
+	if meta, ok := o.exprs[exprID]; ok {
+		mode = meta.Mode
+	}
+	completed.Result = resultValue(resultStr, mode, FormatOptions{})
+	o.emit(completed)
+	o.forgetExpression(exprID)
+	for _, f := range o.takeFollowers(exprID) {
+		if err := o.complete(f.exprID, resultStr); err != nil {
+			log.Printf("Ошибка обновления выражения %s: %v", f.exprID, err)
+		}
+	}
+	return nil
+}
+
+func (o *Orchestrator) dropTasks(exprID string) {
+	list := o.taskList[:0]
+	var handed []*Task
+	for _, t := range o.taskList {
+		if t.ExprID != exprID {
+			list = append(list, t)
+			continue
+		}
+		if next := o.detach(t); next != nil && next.ExprID != exprID {
+			handed = append(handed, next)
+		}
+	}
+	o.taskList = list
+	o.taskQueue.Remove(exprID)
+	if len(handed) == 0 {
+		return
+	}
+	// переданные задачи сохраняют свой ID: строки упавшего выражения нужно
+	// убрать до того, как они запишутся за другим выражением
+	if err := DeleteExpressionState(exprID); err != nil {
+		log.Printf("Ошибка удаления состояния выражения %s: %v", exprID, err)
+	}
+	for _, t := range handed {
+		o.persistExpression(t.ExprID)
+	}
+}
+
+func (o *Orchestrator) updateASTNode(node *ASTNode, result float64, exact string) {
 	node.IsLeaf = true
 	node.Value = result
+	node.Exact = exact
 }
 
 func (o *Orchestrator) findTaskByID(taskID string) (*Task, int) {
 	for i := range o.taskList {
 		if o.taskList[i].ID == taskID {
-			return &o.taskList[i], i
+			return o.taskList[i], i
 		}
 	}
 	return nil, -1
 }
 
+// hasTask сообщает, стоит ли уже на узле задача (в очереди или в аренде)
+func (o *Orchestrator) hasTask(node *ASTNode) bool {
+	for _, t := range o.taskList {
+		if t.Node == node {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *Orchestrator) ProcessAST(exprID string, ast *ASTNode) {
-	var traverse func(*ASTNode)
-	traverse = func(n *ASTNode) {
+	enqueued := false
+	meta := o.exprs[exprID]
+	mode := ModeFloat
+	if meta != nil {
+		mode = meta.Mode
+	}
+	var traverse func(*ASTNode, []int)
+	traverse = func(n *ASTNode, path []int) {
 		if n == nil || n.IsLeaf {
 			return
 		}
-		traverse(n.Right)
-		traverse(n.Left)
-		if n.Left != nil && n.Right != nil && n.Left.IsLeaf && n.Right.IsLeaf {
+		children := n.Children()
+		ready := len(children) > 0
+		for i := len(children) - 1; i >= 0; i-- {
+			traverse(children[i], append(path, i))
+			if children[i] == nil || !children[i].IsLeaf {
+				ready = false
+			}
+		}
+		if ready && n.Operator == "neg" {
+			// смена знака ничего не стоит, агенту её не отдаём
+			n.IsLeaf = true
+			n.Value = -n.Left.Value
+			if mode == ModeExact {
+				n.Exact = calculation.NegExact(n.Left.Exact)
+			}
+			return
+		}
+		if ready && !o.hasTask(n) {
 			taskID, _ := generateRandomID(8)
 			task := &Task{
-				ID:            taskID,
-				ExprID:        exprID,
-				Arg1:          n.Left.Value,
-				Arg2:          n.Right.Value,
-				Operation:     n.Operator,
-				OperationTime: o.getOperationTime(n.Operator),
-				Node:          n,
+				ID:     taskID,
+				ExprID: exprID,
+				Node:   n,
+				Path:   append([]int(nil), path...),
+			}
+			if meta != nil {
+				task.UserID = meta.UserID
+				task.Priority = meta.Priority
+			}
+			if n.Func != "" {
+				task.Operation = n.Func
+				for _, arg := range n.Args {
+					task.Args = append(task.Args, Number(arg.Value))
+				}
+			} else {
+				task.Operation = n.Operator
+				task.Arg1 = Number(n.Left.Value)
+				task.Arg2 = Number(n.Right.Value)
 			}
-			o.taskList = append(o.taskList, *task)
-			o.taskQueue = append(o.taskQueue, task)
+			if mode == ModeExact {
+				for _, c := range children {
+					task.ExactArgs = append(task.ExactArgs, c.ExactString())
+				}
+			}
+			task.OperationTime = o.getOperationTime(task.Operation)
+			task.Key = taskKey(n, mode)
+			o.taskList = append(o.taskList, task)
+			if !o.share(task) {
+				o.emit(taskEvent(EventTaskShared, task))
+				return
+			}
+			o.taskQueue.Push(task)
+			o.emit(taskEvent(EventTaskEnqueued, task))
+			enqueued = true
 		}
 	}
-	traverse(ast)
+	traverse(ast, nil)
+	if enqueued {
+		o.notifyTasks()
+	}
 }
 
 func (o *Orchestrator) getOperationTime(operator string) int {
@@ -317,8 +785,14 @@ func (o *Orchestrator) getOperationTime(operator string) int {
 		envVar = "TIME_MULTIPLICATIONS_MS"
 	case "/":
 		envVar = "TIME_DIVISIONS_MS"
+	case "^":
+		envVar = "TIME_POWER_MS"
 	default:
-		return 1000
+		if !calculation.IsFunc(operator) {
+			return 1000
+		}
+		// у каждой функции своё время: TIME_SQRT_MS, TIME_MAX_MS, ...
+		envVar = "TIME_" + strings.ToUpper(operator) + "_MS"
 	}
 	timeStr := os.Getenv(envVar)
 	if timeStr == "" {
@@ -340,6 +814,9 @@ func (o *Orchestrator) Run() error {
 	http.HandleFunc("/api/v1/register", o.RegisterHandler)
 	http.HandleFunc("/api/v1/login", o.LoginHandler)
 	http.HandleFunc("/api/v1/expressions", o.getAllExpressionsHandler)
+	http.HandleFunc("/api/v1/settings", o.settingsHandler)
+	http.HandleFunc("/api/v1/variables", o.variablesHandler)
+	http.HandleFunc("/api/v1/variables/", o.variablesHandler)
 	http.HandleFunc("/internal/task", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
 			o.getTaskHandler(w, r)
@@ -348,13 +825,45 @@ func (o *Orchestrator) Run() error {
 			o.postTaskHandler(w, r)
 		}
 	})
-	http.HandleFunc("/api/v1/expressions/", o.getExpressionByIDHandler)
+	http.HandleFunc("/api/v1/expressions/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/events") {
+			o.expressionEventsHandler(w, r)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			o.cancelExpressionHandler(w, r)
+			return
+		}
+		o.getExpressionByIDHandler(w, r)
+	})
+	http.HandleFunc("/api/v1/events", o.userEventsHandler)
+	http.HandleFunc("/api/v1/webhooks", o.webhooksHandler)
+	http.HandleFunc("/api/v1/webhooks/", o.webhooksHandler)
+	http.HandleFunc("/internal/agents/register", o.registerAgentHandler)
+	http.HandleFunc("/internal/agents/heartbeat", o.heartbeatHandler)
+	http.HandleFunc("/api/v1/admin/agents", o.agentsHandler)
+	go o.watchLeases()
+
+	srv := &http.Server{Addr: ":8080"}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Printf("Остановка сервера")
+		// потоки SSE сами не завершаются, поэтому ждём не дольше shutdownTimeout
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			srv.Close()
+		}
+	}()
 	log.Printf("Сервер запущен")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal("Ошибка при запуске сервера:", err)
-		return nil
-	} else {
-		return err
 	}
-
+	o.Close()
+	return nil
 }
+
+// shutdownTimeout — сколько сервер ждёт завершения запросов при остановке
+const shutdownTimeout = 10 * time.Second
@@ -0,0 +1,113 @@
+package application
+
+import (
+	"log"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+// Состояние незавершённых выражений (AST и задачи) хранится в БД, поэтому
+// после перезапуска оркестратор продолжает вычисление с того же места.
+
+func (o *Orchestrator) persistExpression(exprID string) {
+	ast, ok := o.astStore[exprID]
+	if !ok {
+		return
+	}
+	var tasks []*Task
+	for _, t := range o.taskList {
+		if t.ExprID == exprID {
+			tasks = append(tasks, t)
+		}
+	}
+	if err := SaveExpressionState(exprID, ast, tasks); err != nil {
+		log.Printf("Ошибка сохранения состояния выражения %s: %v", exprID, err)
+	}
+}
+
+// forgetExpression убирает выражение из памяти и из сохранённого состояния
+func (o *Orchestrator) forgetExpression(exprID string) {
+	delete(o.astStore, exprID)
+	if meta, ok := o.exprs[exprID]; ok && o.inflight[meta.Normalized] == exprID {
+		delete(o.inflight, meta.Normalized)
+	}
+	delete(o.exprs, exprID)
+	if err := DeleteExpressionState(exprID); err != nil {
+		log.Printf("Ошибка удаления состояния выражения %s: %v", exprID, err)
+	}
+}
+
+func (o *Orchestrator) restore() {
+	pending, err := LoadPendingExpressions()
+	if err != nil {
+		log.Printf("Ошибка восстановления незавершённых выражений: %v", err)
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, p := range pending {
+		ast := p.AST
+		if ast == nil {
+			// состояние не успели сохранить — начинаем вычисление заново
+			// с теми же значениями переменных
+			ast, err = calculation.ParseASTWith(p.Expression, p.Variables)
+			if err == nil && p.References[calculation.RefLast] != "" {
+				calculation.ReplaceReference(ast, calculation.RefLast, p.References[calculation.RefLast])
+			}
+			if err != nil {
+				log.Printf("Выражение %s не удалось разобрать при восстановлении: %v", p.ID, err)
+				if err := UpdateExpressionError(p.ID, "parse_error", err.Error()); err != nil {
+					log.Printf("Ошибка обновления выражения %s: %v", p.ID, err)
+				}
+				o.finish(p.ID)
+				continue
+			}
+			// упрощаем так же, как при создании, чтобы дерево совпадало с
+			// сохранёнными rewrites. Без них оптимизатор либо был выключен,
+			// либо ничего не менял.
+			if len(p.Rewrites) > 0 {
+				if p.Mode == ModeExact {
+					ast, _ = calculation.OptimizeExact(ast)
+				} else {
+					ast, _ = calculation.Optimize(ast)
+				}
+			}
+		}
+		o.astStore[p.ID] = ast
+		o.exprs[p.ID] = &exprMeta{UserID: p.UserID, Priority: p.Priority, Deadline: p.Deadline, Normalized: p.Normalized, Mode: p.Mode, Digits: p.Digits}
+		// ведомые выражения после перезапуска считаются сами
+		if p.Normalized != "" {
+			o.lead(p.ID, p.Normalized)
+		}
+		for _, t := range p.Tasks {
+			t.UserID = p.UserID
+			t.Priority = p.Priority
+			t.Node = nodeAt(ast, t.Path)
+			if t.Node == nil || t.Node.IsLeaf {
+				continue
+			}
+			t.Key = taskKey(t.Node, p.Mode)
+			o.taskList = append(o.taskList, t)
+			if t.LeaseID != "" {
+				// задачи в аренде ждут результата от агента; если он не придёт,
+				// их вернёт в очередь watchLeases
+				if _, ok := o.shared[t.Key]; !ok {
+					o.shared[t.Key] = t
+				}
+				continue
+			}
+			if o.share(t) {
+				o.taskQueue.Push(t)
+			}
+		}
+		if !o.linkReferences(p.ID) {
+			continue
+		}
+		if err := o.advance(p.ID); err != nil {
+			log.Printf("Ошибка восстановления выражения %s: %v", p.ID, err)
+			continue
+		}
+		log.Printf("Выражение %s восстановлено", p.ID)
+	}
+}
@@ -0,0 +1,67 @@
+package application
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRestore_ContinuesAfterRestart(t *testing.T) {
+	f := newFixture(t)
+	id := f.submit(`{"expression":"(1+2)*(3+4)"}`).Id
+	leased, ok := f.lease("a1", "")
+	if !ok {
+		t.Fatal("Задача не выдана")
+	}
+
+	f.restart()
+	f.o.mu.Lock()
+	tasks, queued := len(f.o.taskList), f.o.taskQueue.Len()
+	f.o.mu.Unlock()
+	if tasks != 2 || queued != 1 {
+		t.Fatalf("Ожидались 2 задачи, 1 в очереди, получено %d и %d", tasks, queued)
+	}
+	if status, _ := f.status(id); status != 2 {
+		t.Errorf("Ожидался статус 2, получен %d", status)
+	}
+
+	// аренда пережила перезапуск
+	if code := f.report("a1", compute(t, leased)); code != http.StatusOK {
+		t.Fatalf("Ожидался код 200, получен %d", code)
+	}
+	f.drain()
+	if status, result := f.status(id); status != 3 || result != "21" {
+		t.Errorf("Получено %d, %q", status, result)
+	}
+}
+
+// Состояние не успело сохраниться: выражение разбирается заново и должно
+// упроститься так же, как при создании.
+func TestRestore_ReparsedExpressionIsOptimized(t *testing.T) {
+	for _, tc := range []struct {
+		name, body, root string
+	}{
+		{"float", `{"expression":"(2+3)*1"}`, "+"},
+		{"exact", `{"expression":"(2+3)*1","mode":"exact"}`, "+"},
+		{"off", `{"expression":"(2+3)*1","optimize":false}`, "*"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			id := f.submit(tc.body).Id
+			if err := DeleteExpressionState(id); err != nil {
+				t.Fatal(err)
+			}
+
+			f.restart()
+			f.o.mu.Lock()
+			root := f.o.astStore[id]
+			f.o.mu.Unlock()
+			if root == nil || root.Operator != tc.root {
+				t.Errorf("Ожидался корень %q, получено %+v", tc.root, root)
+			}
+			f.drain()
+			if status, result := f.status(id); status != 3 || result != "5" {
+				t.Errorf("Получено %d, %q", status, result)
+			}
+		})
+	}
+}
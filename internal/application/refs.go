@@ -0,0 +1,135 @@
+package application
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+// Выражение может ссылаться на результат другого выражения того же
+// пользователя: ans — последнее отправленное, $id — любое по ID. ans
+// заменяется на ID при отправке, а как были разрешены ссылки, сохраняется в
+// выражении (поле references). Результаты готовых выражений подставляются
+// сразу. Ещё не готовые записываются в o.dependents: остальное дерево
+// считается, а ссылка ждёт, пока finish не сообщит о завершении. Если
+// выражение, на которое ссылаются, завершилось не результатом, ссылающееся
+// получает ошибку dependency_failed.
+
+const codeDependencyFailed = "dependency_failed"
+
+// getUserExpression читает выражение и проверяет, что оно принадлежит
+// пользователю. Если нет — возвращает HTTP-код и сообщение для ответа.
+func getUserExpression(exprID, userID string) (*FullExpression, int, string) {
+	expr, err := GetExpressionByID(exprID)
+	if err != nil {
+		return nil, http.StatusNotFound, "выражение не существует"
+	}
+	if expr.UserID != userID {
+		return nil, http.StatusForbidden, "отказано в доступе"
+	}
+	return expr, http.StatusOK, ""
+}
+
+// refValue переводит результат выражения dep в значение для выражения режима mode
+func refValue(dep *FullExpression, mode string) (Variable, error) {
+	raw := dep.RawResult.String
+	r, ok := new(big.Rat).SetString(raw)
+	if dep.Mode == ModeExact {
+		if !ok {
+			return Variable{}, fmt.Errorf("неверный результат выражения %s: %q", dep.ExpressionID, raw)
+		}
+		f, _ := r.Float64()
+		return Variable{Value: Number(f), Exact: r.RatString()}, nil
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return Variable{}, fmt.Errorf("неверный результат выражения %s: %q", dep.ExpressionID, raw)
+	}
+	v := Variable{Value: Number(f)}
+	if ok {
+		v.Exact = r.RatString()
+	} else if mode == ModeExact {
+		// NaN и бесконечности
+		return Variable{}, fmt.Errorf("%w: результат выражения %s — %s", calculation.ErrInexact, dep.ExpressionID, raw)
+	}
+	return v, nil
+}
+
+// linkReferences подставляет в дерево выражения результаты готовых выражений,
+// на которые оно ссылается, и подписывает его на ещё не готовые. Возвращает
+// false, если выражение пришлось завершить ошибкой. Вызывается под o.mu.
+func (o *Orchestrator) linkReferences(exprID string) bool {
+	ast, ok := o.astStore[exprID]
+	if !ok {
+		return true
+	}
+	mode := ModeFloat
+	if meta, ok := o.exprs[exprID]; ok {
+		mode = meta.Mode
+	}
+	for _, ref := range calculation.References(ast) {
+		// выражение в o.exprs ещё считается
+		if _, pending := o.exprs[ref]; pending {
+			o.addDependent(ref, exprID)
+			continue
+		}
+		dep, err := GetExpressionByID(ref)
+		if err != nil {
+			o.failReference(exprID, codeDependencyFailed, fmt.Sprintf("выражение %s не найдено", ref))
+			return false
+		}
+		if dep.StatusID != 3 {
+			o.failReference(exprID, codeDependencyFailed, fmt.Sprintf("выражение %s завершилось со статусом %s", ref, getStatusName(dep.StatusID)))
+			return false
+		}
+		v, err := refValue(dep, mode)
+		if err != nil {
+			o.failReference(exprID, calculation.ErrorCode(err), err.Error())
+			return false
+		}
+		calculation.BindReference(ast, ref, v)
+	}
+	return true
+}
+
+func (o *Orchestrator) failReference(exprID, code, message string) {
+	log.Printf("Выражение %s: %s", exprID, message)
+	if err := o.failExpression(exprID, code, message); err != nil {
+		log.Printf("Ошибка обновления выражения %s: %v", exprID, err)
+	}
+}
+
+func (o *Orchestrator) addDependent(depID, exprID string) {
+	for _, id := range o.dependents[depID] {
+		if id == exprID {
+			return
+		}
+	}
+	o.dependents[depID] = append(o.dependents[depID], exprID)
+	log.Printf("Выражение %s ждёт результата %s", exprID, depID)
+}
+
+// resolveDependents продолжает выражения, ждавшие завершения depID.
+// Запускается из finish отдельной горутиной, когда depID уже убрано из памяти.
+func (o *Orchestrator) resolveDependents(depID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	waiting := o.dependents[depID]
+	delete(o.dependents, depID)
+	for _, exprID := range waiting {
+		if _, ok := o.astStore[exprID]; !ok {
+			// отменено или не успело к дедлайну, пока ждало
+			continue
+		}
+		if !o.linkReferences(exprID) {
+			continue
+		}
+		if err := o.advance(exprID); err != nil {
+			log.Printf("Ошибка продолжения выражения %s: %v", exprID, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package application
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAns_SkipsUnfinishedExpressions(t *testing.T) {
+	f := newFixture(t)
+	cancelled := f.submit(`{"expression":"7+1"}`).Id
+	f.cancel(cancelled, f.token)
+	rec := serve(f.o.CreateHandler, http.MethodPost, "/api/v1/calculate", `{"expression":"ans*2"}`, userHeader(f.token))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Ожидался код 422, получен %d: %s", rec.Code, rec.Body)
+	}
+
+	done := f.submit(`{"expression":"2+3"}`).Id
+	f.drain()
+	cancelled = f.submit(`{"expression":"4+4"}`).Id
+	f.cancel(cancelled, f.token)
+
+	id := f.submit(`{"expression":"ans*2"}`).Id
+	expr, err := GetExpressionByID(id)
+	if err != nil || expr.References["ans"] != done {
+		t.Fatalf("ans должно указывать на %s, получено %+v (%v)", done, expr, err)
+	}
+	f.drain()
+	if status, result := f.status(id); status != 3 || result != "10" {
+		t.Errorf("Получено %d, %q", status, result)
+	}
+}
@@ -0,0 +1,214 @@
+package application
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Реестр агентов: агент регистрируется при старте и шлёт heartbeat, а
+// оркестратор помнит, когда видел его в последний раз и сколько задач у него.
+
+// agentHeaderID — заголовок, в котором агент передаёт свой ID
+const agentHeaderID = "X-Agent-ID"
+
+// агент, молчащий дольше agentForgetAfter и без задач, удаляется из реестра
+const agentForgetAfter = 10 * time.Minute
+
+type AgentInfo struct {
+	ID             string    `json:"id"`
+	Hostname       string    `json:"hostname"`
+	ComputingPower int       `json:"computing_power"`
+	Version        string    `json:"version"`
+	RegisteredAt   time.Time `json:"registered_at"`
+	LastSeen       time.Time `json:"last_seen"`
+	InFlight       int       `json:"in_flight"`
+	Completed      int       `json:"completed"`
+	Alive          bool      `json:"alive"`
+	token          string
+}
+
+func heartbeatInterval() time.Duration {
+	ms, err := strconv.Atoi(os.Getenv("AGENT_HEARTBEAT_MS"))
+	if err != nil || ms <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// агент считается живым, пока не пропустил три heartbeat подряд
+func agentTimeout() time.Duration {
+	return 3 * heartbeatInterval()
+}
+
+// authenticateAgent проверяет ID агента и выданный ему при регистрации токен.
+// Для опознанного агента обновляет время последней связи, иначе возвращает nil.
+func (o *Orchestrator) authenticateAgent(r *http.Request) *AgentInfo {
+	info, ok := o.agents[r.Header.Get(agentHeaderID)]
+	if !ok {
+		return nil
+	}
+	token, ok := bearerToken(r)
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(info.token)) != 1 {
+		return nil
+	}
+	info.LastSeen = time.Now()
+	return info
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(auth, "Bearer "), true
+}
+
+func (o *Orchestrator) forgetSilentAgents(now time.Time) {
+	for id, info := range o.agents {
+		if now.Sub(info.LastSeen) > agentForgetAfter && o.agentInFlight(id) == 0 {
+			delete(o.agents, id)
+		}
+	}
+}
+
+func (o *Orchestrator) agentInFlight(agentID string) int {
+	n := 0
+	for _, t := range o.taskList {
+		if t.LeaseID != "" && t.AgentID == agentID {
+			n++
+		}
+	}
+	return n
+}
+
+// registerAgentHandler регистрирует агента, знающего общий секрет AGENT_SECRET,
+// и выдаёт ему личный токен для /internal/task и heartbeat
+func (o *Orchestrator) registerAgentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error":"метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	secret := os.Getenv("AGENT_SECRET")
+	if secret == "" {
+		log.Printf("AGENT_SECRET не задан, регистрация агентов отключена")
+		http.Error(w, `{"error":"регистрация агентов отключена"}`, http.StatusForbidden)
+		return
+	}
+	if got, ok := bearerToken(r); !ok || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+		http.Error(w, `{"error":"неверный секрет агента"}`, http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		ID             string `json:"id"`
+		Hostname       string `json:"hostname"`
+		ComputingPower int    `json:"computing_power"`
+		Version        string `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"невалидный json"}`, http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+	if strings.TrimSpace(req.ID) == "" {
+		http.Error(w, `{"error":"не указан id агента"}`, http.StatusBadRequest)
+		return
+	}
+	token, err := generateRandomID(32)
+	if err != nil {
+		http.Error(w, `{"error":"ошибка сервера"}`, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	o.mu.Lock()
+	info, ok := o.agents[req.ID]
+	if !ok {
+		info = &AgentInfo{ID: req.ID, RegisteredAt: now}
+		o.agents[req.ID] = info
+	}
+	info.Hostname = req.Hostname
+	info.ComputingPower = req.ComputingPower
+	info.Version = req.Version
+	info.LastSeen = now
+	// повторная регистрация отзывает прежний токен
+	info.token = token
+	o.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":                "ok",
+		"token":                 token,
+		"heartbeat_interval_ms": heartbeatInterval().Milliseconds(),
+	})
+}
+
+func (o *Orchestrator) heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error":"метод не поддерживается"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	o.mu.Lock()
+	info := o.authenticateAgent(r)
+	o.mu.Unlock()
+	if info == nil {
+		// например, оркестратор перезапустился — агенту нужно зарегистрироваться заново
+		http.Error(w, `{"error":"агент не авторизован"}`, http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// agentsHandler показывает состояние парка агентов и очереди. Доступен
+// только с токеном из ADMIN_TOKEN.
+func (o *Orchestrator) agentsHandler(w http.ResponseWriter, r *http.Request) {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		http.Error(w, "админский доступ не настроен", http.StatusForbidden)
+		return
+	}
+	if got, ok := bearerToken(r); !ok || subtle.ConstantTimeCompare([]byte(got), []byte(adminToken)) != 1 {
+		http.Error(w, "неверный токен", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	o.mu.Lock()
+	agents := make([]AgentInfo, 0, len(o.agents))
+	alive := 0
+	for id, info := range o.agents {
+		a := *info
+		a.InFlight = o.agentInFlight(id)
+		a.Alive = now.Sub(a.LastSeen) <= agentTimeout()
+		if a.Alive {
+			alive++
+		}
+		agents = append(agents, a)
+	}
+	leased := 0
+	for _, t := range o.taskList {
+		if t.LeaseID != "" {
+			leased++
+		}
+	}
+	queued := o.taskQueue.Len()
+	pending := len(o.astStore)
+	o.mu.Unlock()
+
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agents":              agents,
+		"alive_agents":        alive,
+		"queued_tasks":        queued,
+		"leased_tasks":        leased,
+		"pending_expressions": pending,
+	})
+}
@@ -0,0 +1,99 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRegistry_RegisterAndHeartbeat(t *testing.T) {
+	f := newFixture(t)
+	t.Setenv("AGENT_SECRET", "agent-secret")
+	t.Setenv("AGENT_HEARTBEAT_MS", "1000")
+
+	if rec := serve(f.o.registerAgentHandler, http.MethodPost, "/internal/agents/register", `{"id":"a2"}`, userHeader("wrong")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Неверный секрет: ожидался код 401, получен %d", rec.Code)
+	}
+	if rec := serve(f.o.registerAgentHandler, http.MethodPost, "/internal/agents/register", `{"id":" "}`, userHeader("agent-secret")); rec.Code != http.StatusBadRequest {
+		t.Errorf("Пустой id: ожидался код 400, получен %d", rec.Code)
+	}
+
+	rec := serve(f.o.registerAgentHandler, http.MethodPost, "/internal/agents/register", `{"id":"a2","computing_power":4}`, userHeader("agent-secret"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Ожидался код 200, получен %d: %s", rec.Code, rec.Body)
+	}
+	var reg struct {
+		Token    string `json:"token"`
+		Interval int64  `json:"heartbeat_interval_ms"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&reg); err != nil || reg.Token == "" || reg.Interval != 1000 {
+		t.Fatalf("Неверный ответ регистрации: %+v, %v", reg, err)
+	}
+
+	header := userHeader(reg.Token)
+	header.Set(agentHeaderID, "a2")
+	if rec := serve(f.o.heartbeatHandler, http.MethodPost, "/internal/agents/heartbeat", "", header); rec.Code != http.StatusOK {
+		t.Errorf("Ожидался код 200, получен %d", rec.Code)
+	}
+	// токен из регистрации открывает и выдачу задач
+	if rec := serve(f.o.getTaskHandler, http.MethodGet, "/internal/task", "", header); rec.Code != http.StatusNotFound {
+		t.Errorf("Пустая очередь: ожидался код 404, получен %d", rec.Code)
+	}
+
+	// повторная регистрация отзывает прежний токен
+	serve(f.o.registerAgentHandler, http.MethodPost, "/internal/agents/register", `{"id":"a2"}`, userHeader("agent-secret"))
+	if rec := serve(f.o.heartbeatHandler, http.MethodPost, "/internal/agents/heartbeat", "", header); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Старый токен: ожидался код 401, получен %d", rec.Code)
+	}
+	// агент, которого оркестратор не знает, должен зарегистрироваться заново
+	if rec := serve(f.o.heartbeatHandler, http.MethodPost, "/internal/agents/heartbeat", "", agentHeader("ghost")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Неизвестный агент: ожидался код 401, получен %d", rec.Code)
+	}
+}
+
+func TestRegistry_RegisterDisabledWithoutSecret(t *testing.T) {
+	f := newFixture(t)
+	t.Setenv("AGENT_SECRET", "")
+	if rec := serve(f.o.registerAgentHandler, http.MethodPost, "/internal/agents/register", `{"id":"a2"}`, userHeader("")); rec.Code != http.StatusForbidden {
+		t.Errorf("Ожидался код 403, получен %d", rec.Code)
+	}
+}
+
+func TestRegistry_AgentsEndpoint(t *testing.T) {
+	f := newFixture(t)
+	f.addAgent("a2")
+	f.submit(`{"expression":"(1+2)*(3+4)"}`)
+	if _, ok := f.lease("a1", ""); !ok {
+		t.Fatal("Задача не выдана")
+	}
+
+	t.Setenv("ADMIN_TOKEN", "")
+	if rec := serve(f.o.agentsHandler, http.MethodGet, "/api/v1/admin/agents", "", userHeader("")); rec.Code != http.StatusForbidden {
+		t.Errorf("Без ADMIN_TOKEN: ожидался код 403, получен %d", rec.Code)
+	}
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	if rec := serve(f.o.agentsHandler, http.MethodGet, "/api/v1/admin/agents", "", userHeader("wrong")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Неверный токен: ожидался код 401, получен %d", rec.Code)
+	}
+
+	rec := serve(f.o.agentsHandler, http.MethodGet, "/api/v1/admin/agents", "", userHeader("admin-token"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Ожидался код 200, получен %d", rec.Code)
+	}
+	var fleet struct {
+		Agents  []AgentInfo `json:"agents"`
+		Alive   int         `json:"alive_agents"`
+		Queued  int         `json:"queued_tasks"`
+		Leased  int         `json:"leased_tasks"`
+		Pending int         `json:"pending_expressions"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&fleet); err != nil {
+		t.Fatalf("Неверный ответ: %v", err)
+	}
+	if len(fleet.Agents) != 2 || fleet.Alive != 2 || fleet.Queued != 1 || fleet.Leased != 1 || fleet.Pending != 1 {
+		t.Fatalf("Получено %+v", fleet)
+	}
+	if a := fleet.Agents[0]; a.ID != "a1" || a.InFlight != 1 {
+		t.Errorf("Ожидался агент a1 с одной задачей, получено %+v", a)
+	}
+}
@@ -0,0 +1,122 @@
+package application
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+// Результат в API — число JSON, записанное по настройкам формата выражения
+// или пользователя (GET/PUT /api/v1/settings). NaN и бесконечности
+// отдаются строками "NaN", "Infinity", "-Infinity", дробь точного режима —
+// строкой "1/3", невычисленный результат — null.
+
+// render заполняет Result по сохранённому результату. Настройки выражения
+// важнее настроек пользователя defaults.
+func (e *FullExpression) render(defaults FormatOptions) {
+	opts := defaults
+	if e.Format != nil {
+		opts = e.Format.Merge(defaults)
+	}
+	e.Result = nil
+	if e.RawResult.Valid {
+		e.Result = resultValue(e.RawResult.String, e.Mode, opts)
+	}
+}
+
+// resultValue переводит сохранённый результат в значение для JSON
+func resultValue(raw string, mode string, opts FormatOptions) interface{} {
+	if raw == "" {
+		return nil
+	}
+	if mode == ModeExact {
+		r, ok := new(big.Rat).SetString(raw)
+		if !ok {
+			return raw
+		}
+		if opts.Precision != nil || opts.Significant != nil {
+			return json.Number(calculation.FormatRat(r, opts))
+		}
+		// дробь или десятичная запись с digits знаками — как сохранено
+		if strings.Contains(raw, "/") {
+			return raw
+		}
+		return json.Number(raw)
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return raw
+	}
+	return numberValue(v, opts)
+}
+
+// numberValue — число для JSON; NaN и бесконечности — строками
+func numberValue(v float64, opts FormatOptions) interface{} {
+	s := calculation.Format(v, opts)
+	switch s {
+	case calculation.TextNaN, calculation.TextInf, calculation.TextNegInf:
+		return s
+	}
+	return json.Number(s)
+}
+
+// userFormat возвращает настройки формата пользователя; ошибка чтения не
+// мешает ответить с форматом по умолчанию
+func userFormat(userID string) FormatOptions {
+	settings, err := GetUserSettings(userID)
+	if err != nil {
+		return FormatOptions{}
+	}
+	return settings.Format
+}
+
+// settingsHandler обрабатывает /api/v1/settings: GET — текущие настройки,
+// PUT {"format": {...}} — заменить
+func (o *Orchestrator) settingsHandler(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		http.Error(w, "неверный Authorization header", http.StatusUnauthorized)
+		return
+	}
+	tokenStr := strings.TrimPrefix(auth, "Bearer ")
+	claims, err := ParseJWT(tokenStr)
+	if err != nil {
+		http.Error(w, "неверный токен", http.StatusUnauthorized)
+		return
+	}
+	userID := claims.UserID
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var settings UserSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "неверный JSON", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if err := settings.Format.Validate(); err != nil {
+			http.Error(w, "неверный формат: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := SaveUserSettings(userID, settings); err != nil {
+			http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings, err := GetUserSettings(userID)
+	if err != nil {
+		http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
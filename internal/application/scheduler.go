@@ -0,0 +1,237 @@
+package application
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Планировщик решает, какую задачу из очереди выдать агенту следующей.
+//
+// Сначала выбирается класс с наибольшим эффективным приоритетом: приоритет
+// выражения плюс по единице за каждый интервал старения (TASK_AGING_MS),
+// проведённый задачей в очереди. Так задачи с низким приоритетом рано или
+// поздно догоняют новые высокоприоритетные и не голодают вечно.
+//
+// Внутри класса действует политика SCHEDULER_POLICY:
+//   - round_robin (по умолчанию) — пользователи обслуживаются по очереди,
+//     поэтому тысяча выражений одного не задерживает остальных;
+//   - fifo — общая очередь в порядке поступления.
+//
+// Задачи одного выражения выдаются в том порядке, в котором попали в очередь.
+
+const (
+	PolicyFIFO       = "fifo"
+	PolicyRoundRobin = "round_robin"
+)
+
+type Scheduler interface {
+	// Push ставит задачу в конец очереди
+	Push(t *Task)
+	// PushFront возвращает задачу в начало очереди, например после истечения аренды
+	PushFront(t *Task)
+	// Pop выдаёт следующую задачу или nil, если очередь пуста
+	Pop() *Task
+	// Remove снимает из очереди все задачи выражения
+	Remove(exprID string)
+	Len() int
+}
+
+// NewScheduler создаёт планировщик с указанной политикой. Для неизвестной
+// политики используется round_robin. aging <= 0 отключает старение.
+func NewScheduler(policy string, aging time.Duration) Scheduler {
+	switch policy {
+	case PolicyFIFO:
+		return &fifoScheduler{aging: aging}
+	case PolicyRoundRobin, "":
+		return newRoundRobinScheduler(aging)
+	default:
+		log.Printf("Неизвестная политика планировщика %q, используем %s", policy, PolicyRoundRobin)
+		return newRoundRobinScheduler(aging)
+	}
+}
+
+func schedulerFromEnv() Scheduler {
+	return NewScheduler(os.Getenv("SCHEDULER_POLICY"), agingInterval())
+}
+
+func agingInterval() time.Duration {
+	ms, err := strconv.Atoi(os.Getenv("TASK_AGING_MS"))
+	if err != nil || ms < 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// effectivePriority — приоритет задачи с учётом времени, проведённого в очереди
+func effectivePriority(t *Task, now time.Time, aging time.Duration) int {
+	if aging <= 0 {
+		return t.Priority
+	}
+	return t.Priority + int(now.Sub(t.QueuedAt)/aging)
+}
+
+// topClass возвращает наибольший эффективный приоритет среди задач
+func topClass(tasks []*Task, now time.Time, aging time.Duration, top int, found bool) (int, bool) {
+	for _, t := range tasks {
+		if p := effectivePriority(t, now, aging); !found || p > top {
+			top, found = p, true
+		}
+	}
+	return top, found
+}
+
+func markQueued(t *Task) {
+	// задача, вернувшаяся после аренды, сохраняет свой возраст
+	if t.QueuedAt.IsZero() {
+		t.QueuedAt = time.Now()
+	}
+}
+
+type fifoScheduler struct {
+	queue []*Task
+	aging time.Duration
+}
+
+func (s *fifoScheduler) Push(t *Task) {
+	markQueued(t)
+	s.queue = append(s.queue, t)
+}
+
+func (s *fifoScheduler) PushFront(t *Task) {
+	markQueued(t)
+	s.queue = append([]*Task{t}, s.queue...)
+}
+
+func (s *fifoScheduler) Pop() *Task {
+	now := time.Now()
+	top, ok := topClass(s.queue, now, s.aging, 0, false)
+	if !ok {
+		return nil
+	}
+	for i, t := range s.queue {
+		if effectivePriority(t, now, s.aging) == top {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *fifoScheduler) Remove(exprID string) {
+	s.queue = removeExprTasks(s.queue, exprID)
+}
+
+func (s *fifoScheduler) Len() int {
+	return len(s.queue)
+}
+
+// roundRobinScheduler держит отдельную очередь на каждого пользователя и
+// выдаёт задачи из них по кругу. Пользователь остаётся в кольце, пока у него
+// есть задачи.
+type roundRobinScheduler struct {
+	queues map[string][]*Task
+	ring   []string
+	next   int
+	size   int
+	aging  time.Duration
+}
+
+func newRoundRobinScheduler(aging time.Duration) *roundRobinScheduler {
+	return &roundRobinScheduler{queues: make(map[string][]*Task), aging: aging}
+}
+
+func (s *roundRobinScheduler) Push(t *Task) {
+	markQueued(t)
+	if _, ok := s.queues[t.UserID]; !ok {
+		s.ring = append(s.ring, t.UserID)
+	}
+	s.queues[t.UserID] = append(s.queues[t.UserID], t)
+	s.size++
+}
+
+func (s *roundRobinScheduler) PushFront(t *Task) {
+	markQueued(t)
+	if _, ok := s.queues[t.UserID]; !ok {
+		// пользователь обслуживается следующим
+		s.ring = append(s.ring[:s.next], append([]string{t.UserID}, s.ring[s.next:]...)...)
+	}
+	s.queues[t.UserID] = append([]*Task{t}, s.queues[t.UserID]...)
+	s.size++
+}
+
+func (s *roundRobinScheduler) Pop() *Task {
+	if s.size == 0 {
+		return nil
+	}
+	now := time.Now()
+	top, found := 0, false
+	for _, user := range s.ring {
+		top, found = topClass(s.queues[user], now, s.aging, top, found)
+	}
+
+	// первый по кругу пользователь, у которого есть задача из верхнего класса
+	for k := 0; k < len(s.ring); k++ {
+		pos := (s.next + k) % len(s.ring)
+		user := s.ring[pos]
+		queue := s.queues[user]
+		for i, t := range queue {
+			if effectivePriority(t, now, s.aging) != top {
+				continue
+			}
+			s.size--
+			if len(queue) == 1 {
+				// очередь пользователя опустела — убираем его из кольца,
+				// следующим становится тот, кто стоял за ним
+				delete(s.queues, user)
+				s.ring = append(s.ring[:pos], s.ring[pos+1:]...)
+				s.next = pos
+			} else {
+				s.queues[user] = append(queue[:i], queue[i+1:]...)
+				s.next = pos + 1
+			}
+			if s.next >= len(s.ring) {
+				s.next = 0
+			}
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *roundRobinScheduler) Remove(exprID string) {
+	ring := s.ring[:0]
+	for i, user := range s.ring {
+		before := len(s.queues[user])
+		queue := removeExprTasks(s.queues[user], exprID)
+		s.size -= before - len(queue)
+		if len(queue) == 0 {
+			delete(s.queues, user)
+			if i < s.next {
+				s.next--
+			}
+			continue
+		}
+		s.queues[user] = queue
+		ring = append(ring, user)
+	}
+	s.ring = ring
+	if s.next >= len(s.ring) {
+		s.next = 0
+	}
+}
+
+func (s *roundRobinScheduler) Len() int {
+	return s.size
+}
+
+func removeExprTasks(tasks []*Task, exprID string) []*Task {
+	kept := tasks[:0]
+	for _, t := range tasks {
+		if t.ExprID != exprID {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
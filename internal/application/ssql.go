@@ -7,7 +7,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"time"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
 )
 
 var DB *sql.DB
@@ -24,11 +28,34 @@ type OutExpression struct {
 	StatusID     int
 }
 type FullExpression struct {
-	ExpressionID string         `json:"id"`
-	Expression   string         `json:"expression"`
-	Result       sql.NullString `json:"result"`
-	StatusID     int            `json:"status_id"`
-	UserID       string         `json:"user_id"`
+	ExpressionID string           `json:"id"`
+	Expression   string           `json:"expression"`
+	Result       interface{}      `json:"result"`
+	RawResult    sql.NullString   `json:"-"`
+	StatusID     int              `json:"status_id"`
+	UserID       string           `json:"user_id"`
+	Priority     int              `json:"priority"`
+	Deadline     *time.Time       `json:"deadline,omitempty"`
+	WebhookURL   string           `json:"webhook_url,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+	FinishedAt   *time.Time       `json:"finished_at,omitempty"`
+	Cached       bool             `json:"cached,omitempty"`
+	Rewrites     []Rewrite        `json:"rewrites,omitempty"`
+	Mode         string           `json:"mode"`
+	Digits       *int             `json:"digits,omitempty"`
+	Format       *FormatOptions   `json:"format,omitempty"`
+	Error        *ExpressionError `json:"error,omitempty"`
+
+	// Variables — значения имён, подставленных в выражение при отправке
+	Variables map[string]Variable `json:"variables,omitempty"`
+	// References — на какие выражения указывают ссылки: ans и $id → ID
+	References map[string]string `json:"references,omitempty"`
+}
+
+// ExpressionError — причина, по которой выражение получило статус error
+type ExpressionError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 func InitDB(dataSourceName string) error {
@@ -69,11 +96,64 @@ func createTables(db *sql.DB) error {
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY(status_id) REFERENCES statuses(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS expression_asts (
+			expression_id TEXT PRIMARY KEY,
+			ast TEXT NOT NULL,
+			FOREIGN KEY(expression_id) REFERENCES expressions(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS tasks (
+			id TEXT PRIMARY KEY,
+			expression_id TEXT NOT NULL,
+			node_path TEXT NOT NULL,
+			arg1 REAL NOT NULL,
+			arg2 REAL NOT NULL,
+			operation TEXT NOT NULL,
+			operation_time INTEGER NOT NULL,
+			lease_id TEXT,
+			lease_deadline INTEGER,
+			FOREIGN KEY(expression_id) REFERENCES expressions(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(user_id) REFERENCES users(user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			delivery_id TEXT NOT NULL,
+			webhook_id TEXT,
+			expression_id TEXT NOT NULL,
+			url TEXT NOT NULL,
+			attempt INTEGER NOT NULL,
+			status_code INTEGER,
+			error TEXT,
+			success INTEGER NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(expression_id) REFERENCES expressions(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_settings (
+			user_id TEXT PRIMARY KEY,
+			format TEXT,
+			FOREIGN KEY(user_id) REFERENCES users(user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS variables (
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value TEXT NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY(user_id, name),
+			FOREIGN KEY(user_id) REFERENCES users(user_id)
+		)`,
 		`INSERT OR IGNORE INTO statuses (id, name) VALUES 
 			(1, 'cooking'),
 			(2, 'in_progress'),
 			(3, 'completed'),
-			(4, 'error')`,
+			(4, 'error'),
+			(5, 'cancelled'),
+			(6, 'timeout')`,
 	}
 
 	for _, q := range queries {
@@ -81,6 +161,66 @@ func createTables(db *sql.DB) error {
 			return fmt.Errorf("ошибка создания таблицы: %v, запрос: %s", err, q)
 		}
 	}
+
+	// колонки, появившиеся после первой версии схемы
+	columns := []struct{ table, column, decl string }{
+		{"expressions", "error_code", "TEXT"},
+		{"expressions", "error_message", "TEXT"},
+		{"tasks", "args", "TEXT"},
+		{"tasks", "agent_id", "TEXT"},
+		{"expressions", "priority", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "deadline", "INTEGER"},
+		{"expressions", "webhook_url", "TEXT"},
+		{"expressions", "finished_at", "TIMESTAMP"},
+		{"expressions", "normalized", "TEXT"},
+		{"expressions", "source_id", "TEXT"},
+		{"expressions", "rewrites", "TEXT"},
+		{"expressions", "mode", "TEXT NOT NULL DEFAULT 'float'"},
+		{"expressions", "digits", "INTEGER"},
+		{"tasks", "exact_args", "TEXT"},
+		{"expressions", "format", "TEXT"},
+		{"expressions", "variables", "TEXT"},
+		{"expressions", "refs", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(db, c.table, c.column, c.decl); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_expressions_normalized ON expressions(normalized, status_id)`); err != nil {
+		return fmt.Errorf("ошибка создания индекса: %v", err)
+	}
+	return nil
+}
+
+func addColumnIfMissing(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return fmt.Errorf("ошибка чтения схемы %s: %v", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid       int
+			name, typ string
+			notNull   int
+			dflt      sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl)); err != nil {
+		return fmt.Errorf("ошибка добавления колонки %s.%s: %v", table, column, err)
+	}
 	return nil
 }
 
@@ -193,13 +333,88 @@ func Login(w http.ResponseWriter, r *http.Request) (string, int, error) {
 	return userID, http.StatusOK, nil
 }
 
-func InsertExpresions(exprID, userID, expression string, statusId int) error {
+// NewExpression — всё, что известно о выражении в момент его создания
+type NewExpression struct {
+	ID         string
+	UserID     string
+	Expression string
+	Normalized string
+	Priority   int
+	Deadline   time.Time // нулевой — дедлайна нет
+	WebhookURL string
+	Mode       string
+	Digits     int // < 0 — результат дробью
+	Format     *FormatOptions
+	Rewrites   []Rewrite
+	Variables  map[string]Variable
+	Refs       map[string]string
+}
+
+// InsertExpresions сохраняет новое выражение одним запросом, чтобы в БД не
+// оставалось выражений с половиной заполненных полей
+func InsertExpresions(e *NewExpression, statusId int) error {
+	var deadline, digits sql.NullInt64
+	if !e.Deadline.IsZero() {
+		deadline = sql.NullInt64{Int64: e.Deadline.UnixMilli(), Valid: true}
+	}
+	mode := e.Mode
+	if mode == "" {
+		mode = ModeFloat
+	}
+	if mode == ModeExact && e.Digits >= 0 {
+		digits = sql.NullInt64{Int64: int64(e.Digits), Valid: true}
+	}
+	var webhook, format, rewrites, variables, refs sql.NullString
+	if e.WebhookURL != "" {
+		webhook = sql.NullString{String: e.WebhookURL, Valid: true}
+	}
+	if e.Format != nil && !e.Format.IsZero() {
+		data, err := json.Marshal(e.Format)
+		if err != nil {
+			return err
+		}
+		format = sql.NullString{String: string(data), Valid: true}
+	}
+	if len(e.Rewrites) > 0 {
+		data, err := json.Marshal(e.Rewrites)
+		if err != nil {
+			return err
+		}
+		rewrites = sql.NullString{String: string(data), Valid: true}
+	}
+	if len(e.Variables) > 0 {
+		data, err := json.Marshal(e.Variables)
+		if err != nil {
+			return err
+		}
+		variables = sql.NullString{String: string(data), Valid: true}
+	}
+	if len(e.Refs) > 0 {
+		data, err := json.Marshal(e.Refs)
+		if err != nil {
+			return err
+		}
+		refs = sql.NullString{String: string(data), Valid: true}
+	}
 	_, err := DB.Exec(
-		`INSERT INTO expressions (id, user_id, expression, status_id) VALUES (?, ?, ?, ?)`,
-		exprID,
-		userID,
-		expression,
+		`INSERT INTO expressions (id, user_id, expression, status_id, created_at, priority, deadline, webhook_url, normalized, mode, digits, format, rewrites, variables, refs)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID,
+		e.UserID,
+		e.Expression,
 		statusId,
+		// точнее CURRENT_TIMESTAMP, чтобы duration_ms в webhook не округлялся до секунд
+		time.Now().UTC(),
+		e.Priority,
+		deadline,
+		webhook,
+		e.Normalized,
+		mode,
+		digits,
+		format,
+		rewrites,
+		variables,
+		refs,
 	)
 	return err
 }
@@ -273,17 +488,19 @@ func updateGetExpressionStatus(expressionID string, statusID int) {
 		expressionID,
 	)
 	if err != nil {
-		fmt.Errorf("ошибка выполнения запроса: %v", err)
+		log.Printf("ошибка выполнения запроса: %v", err)
+		return
 	}
 
 	// Проверяем, что запрос затронул строки
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		fmt.Errorf("ошибка проверки обновленных строк: %v", err)
+		log.Printf("ошибка проверки обновленных строк: %v", err)
+		return
 	}
 
 	if rowsAffected == 0 {
-		fmt.Errorf("выражение с ID %s не найдено", expressionID)
+		log.Printf("выражение с ID %s не найдено", expressionID)
 	}
 
 }
@@ -328,6 +545,12 @@ func getStatusName(statusID int) string {
 		return "in_progress"
 	case 3:
 		return "completed"
+	case 4:
+		return "error"
+	case 5:
+		return "cancelled"
+	case 6:
+		return "timeout"
 	default:
 		return "unknown"
 	}
@@ -348,16 +571,24 @@ func InsertUserWithID(userID, login, password string) error {
 	return err
 }
 
-func FindSaymExpression(expression string) (string, error) {
-	var expressionId string
-	err := DB.QueryRow(`SELECT expressionId FROM expressions WHERE expression  = ?`, expression).Scan(&expressionId)
+// FindSaymExpression ищет вычисленное выражение с той же канонической
+// записью и возвращает его ID и результат
+func FindSaymExpression(normalized string) (string, string, error) {
+	var expressionId, result string
+	err := DB.QueryRow(
+		`SELECT id, result FROM expressions
+		  WHERE normalized = ? AND status_id = 3 AND result IS NOT NULL
+		  ORDER BY finished_at DESC
+		  LIMIT 1`,
+		normalized,
+	).Scan(&expressionId, &result)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "", fmt.Errorf("выражение не найдено")
+			return "", "", fmt.Errorf("выражение не найдено")
 		}
-		return "", err
+		return "", "", err
 	}
-	return expressionId, nil
+	return expressionId, result, nil
 }
 
 func LenExpresions() (int, error) {
@@ -371,8 +602,71 @@ func LenExpresions() (int, error) {
 	return count, nil
 }
 
+const fullExpressionColumns = "id, expression, result, status_id, user_id, priority, deadline, webhook_url, created_at, finished_at, source_id IS NOT NULL, rewrites, mode, digits, format, variables, refs, error_code, error_message"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFullExpression(row rowScanner) (*FullExpression, error) {
+	var (
+		e                   FullExpression
+		errorCode, errorMsg sql.NullString
+		deadline            sql.NullInt64
+		webhookURL          sql.NullString
+		finishedAt          sql.NullTime
+		rewrites            sql.NullString
+		digits              sql.NullInt64
+		format              sql.NullString
+		variables           sql.NullString
+		refs                sql.NullString
+	)
+	err := row.Scan(&e.ExpressionID, &e.Expression, &e.RawResult, &e.StatusID, &e.UserID, &e.Priority, &deadline,
+		&webhookURL, &e.CreatedAt, &finishedAt, &e.Cached, &rewrites, &e.Mode, &digits, &format, &variables, &refs, &errorCode, &errorMsg)
+	if err != nil {
+		return nil, err
+	}
+	e.WebhookURL = webhookURL.String
+	if finishedAt.Valid {
+		e.FinishedAt = &finishedAt.Time
+	}
+	if deadline.Valid {
+		d := time.UnixMilli(deadline.Int64)
+		e.Deadline = &d
+	}
+	if digits.Valid {
+		d := int(digits.Int64)
+		e.Digits = &d
+	}
+	if format.Valid {
+		if err := json.Unmarshal([]byte(format.String), &e.Format); err != nil {
+			return nil, err
+		}
+	}
+	e.render(FormatOptions{})
+	if rewrites.Valid {
+		if err := json.Unmarshal([]byte(rewrites.String), &e.Rewrites); err != nil {
+			return nil, err
+		}
+	}
+	if variables.Valid {
+		if err := json.Unmarshal([]byte(variables.String), &e.Variables); err != nil {
+			return nil, err
+		}
+	}
+	if refs.Valid {
+		if err := json.Unmarshal([]byte(refs.String), &e.References); err != nil {
+			return nil, err
+		}
+	}
+	if errorCode.Valid {
+		e.Error = &ExpressionError{Code: errorCode.String, Message: errorMsg.String}
+	}
+	return &e, nil
+}
+
 func GetExpressionsByUserID(userID string) ([]FullExpression, error) {
-	rows, err := DB.Query("SELECT id, expression, result, status_id, user_id FROM expressions WHERE user_id = ?", userID)
+	rows, err := DB.Query("SELECT "+fullExpressionColumns+" FROM expressions WHERE user_id = ?", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -380,26 +674,463 @@ func GetExpressionsByUserID(userID string) ([]FullExpression, error) {
 
 	var expressions []FullExpression
 	for rows.Next() {
-		var e FullExpression
-		err := rows.Scan(&e.ExpressionID, &e.Expression, &e.Result, &e.StatusID, &e.UserID)
+		e, err := scanFullExpression(rows)
 		if err != nil {
 			return nil, err
 		}
-		expressions = append(expressions, e)
+		expressions = append(expressions, *e)
 	}
 	return expressions, nil
 }
 
 func GetExpressionByID(exprID string) (*FullExpression, error) {
-	row := DB.QueryRow("SELECT id, expression, result, status_id, user_id FROM expressions WHERE id = ?", exprID)
+	row := DB.QueryRow("SELECT "+fullExpressionColumns+" FROM expressions WHERE id = ?", exprID)
 
-	var e FullExpression
-	err := row.Scan(&e.ExpressionID, &e.Expression, &e.Result, &e.StatusID, &e.UserID)
+	e, err := scanFullExpression(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("not found")
 		}
 		return nil, err
 	}
-	return &e, nil
+	return e, nil
+}
+
+// GetLastExpressionID возвращает ID последнего выражения пользователя, которое
+// вычислено или ещё считается: завершившиеся ошибкой, отменённые и не успевшие
+// к дедлайну пропускаются. Пустая строка — таких выражений нет.
+func GetLastExpressionID(userID string) (string, error) {
+	var id string
+	err := DB.QueryRow(`SELECT id FROM expressions WHERE user_id = ? AND status_id IN (1, 2, 3) ORDER BY created_at DESC, rowid DESC LIMIT 1`, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// SetExpressionSource отмечает, что результат выражения взят у другого.
+// Пустой sourceID снимает отметку.
+func SetExpressionSource(exprID, sourceID string) error {
+	var src sql.NullString
+	if sourceID != "" {
+		src = sql.NullString{String: sourceID, Valid: true}
+	}
+	_, err := DB.Exec(`UPDATE expressions SET source_id = ? WHERE id = ?`, src, exprID)
+	return err
+}
+
+// MarkExpressionFinished запоминает время перехода выражения в конечный статус
+func MarkExpressionFinished(exprID string) error {
+	_, err := DB.Exec(`UPDATE expressions SET finished_at = ? WHERE id = ?`, time.Now().UTC(), exprID)
+	return err
+}
+
+// UpdateExpressionError переводит выражение в статус error и сохраняет причину
+func UpdateExpressionError(exprID, code, message string) error {
+	_, err := DB.Exec(
+		`UPDATE expressions
+            SET status_id = 4, result = NULL, error_code = ?, error_message = ?
+          WHERE id = ?`,
+		code,
+		message,
+		exprID,
+	)
+	return err
+}
+
+// SaveExpressionState сохраняет частично свёрнутое AST выражения и его задачи,
+// чтобы оркестратор мог продолжить вычисление после перезапуска
+func SaveExpressionState(exprID string, ast *ASTNode, tasks []*Task) error {
+	astJSON, err := json.Marshal(ast)
+	if err != nil {
+		return err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT INTO expression_asts (expression_id, ast) VALUES (?, ?)
+		 ON CONFLICT(expression_id) DO UPDATE SET ast = excluded.ast`,
+		exprID, string(astJSON),
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM tasks WHERE expression_id = ?`, exprID); err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		path, _ := json.Marshal(t.Path)
+		var args sql.NullString
+		if len(t.Args) > 0 {
+			argsJSON, _ := json.Marshal(t.Args)
+			args = sql.NullString{String: string(argsJSON), Valid: true}
+		}
+		var exactArgs sql.NullString
+		if len(t.ExactArgs) > 0 {
+			exactJSON, _ := json.Marshal(t.ExactArgs)
+			exactArgs = sql.NullString{String: string(exactJSON), Valid: true}
+		}
+		var leaseID sql.NullString
+		var leaseDeadline sql.NullInt64
+		if t.LeaseID != "" {
+			leaseID = sql.NullString{String: t.LeaseID, Valid: true}
+			leaseDeadline = sql.NullInt64{Int64: t.LeaseDeadline.UnixMilli(), Valid: true}
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO tasks (id, expression_id, node_path, arg1, arg2, args, exact_args, operation, operation_time, lease_id, lease_deadline, agent_id)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			t.ID, exprID, string(path), t.Arg1, t.Arg2, args, exactArgs, t.Operation, t.OperationTime, leaseID, leaseDeadline, t.AgentID,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteExpressionState удаляет сохранённое состояние завершённого выражения
+func DeleteExpressionState(exprID string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM tasks WHERE expression_id = ?`, exprID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM expression_asts WHERE expression_id = ?`, exprID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PendingExpression — незавершённое выражение вместе с сохранённым состоянием
+type PendingExpression struct {
+	ID         string
+	UserID     string
+	Expression string
+	Normalized string
+	Priority   int
+	Deadline   time.Time
+	Mode       string
+	Digits     int
+	Variables  map[string]Variable
+	References map[string]string
+	// Rewrites — упрощения, которые оптимизатор применил при создании
+	Rewrites []Rewrite
+	AST      *ASTNode
+	Tasks    []*Task
+}
+
+// LoadPendingExpressions возвращает выражения в статусах cooking/in_progress.
+// Если состояние выражения не успели сохранить, AST остаётся nil.
+func LoadPendingExpressions() ([]*PendingExpression, error) {
+	rows, err := DB.Query(
+		`SELECT e.id, e.user_id, e.expression, COALESCE(e.normalized, ''), e.priority, e.deadline, e.mode, COALESCE(e.digits, -1), e.variables, e.refs, e.rewrites, a.ast
+		   FROM expressions e
+		   LEFT JOIN expression_asts a ON a.expression_id = e.id
+		  WHERE e.status_id IN (1, 2)
+		  ORDER BY e.created_at, e.rowid`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var pending []*PendingExpression
+	byID := make(map[string]*PendingExpression)
+	for rows.Next() {
+		var (
+			p        PendingExpression
+			deadline sql.NullInt64
+			vars     sql.NullString
+			refs     sql.NullString
+			rewrites sql.NullString
+			astJSON  sql.NullString
+		)
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Expression, &p.Normalized, &p.Priority, &deadline, &p.Mode, &p.Digits, &vars, &refs, &rewrites, &astJSON); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if deadline.Valid {
+			p.Deadline = time.UnixMilli(deadline.Int64)
+		}
+		if vars.Valid {
+			if err := json.Unmarshal([]byte(vars.String), &p.Variables); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("повреждённые переменные выражения %s: %v", p.ID, err)
+			}
+		}
+		if refs.Valid {
+			if err := json.Unmarshal([]byte(refs.String), &p.References); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("повреждённые ссылки выражения %s: %v", p.ID, err)
+			}
+		}
+		if rewrites.Valid {
+			if err := json.Unmarshal([]byte(rewrites.String), &p.Rewrites); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("повреждённые упрощения выражения %s: %v", p.ID, err)
+			}
+		}
+		if astJSON.Valid {
+			if err := json.Unmarshal([]byte(astJSON.String), &p.AST); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("повреждённое AST выражения %s: %v", p.ID, err)
+			}
+		}
+		pending = append(pending, &p)
+		byID[p.ID] = &p
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	taskRows, err := DB.Query(
+		`SELECT id, expression_id, node_path, arg1, arg2, args, exact_args, operation, operation_time, lease_id, lease_deadline, agent_id
+		   FROM tasks ORDER BY rowid`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer taskRows.Close()
+	for taskRows.Next() {
+		var (
+			t             Task
+			path          string
+			args          sql.NullString
+			exactArgs     sql.NullString
+			leaseID       sql.NullString
+			leaseDeadline sql.NullInt64
+			agentID       sql.NullString
+		)
+		if err := taskRows.Scan(&t.ID, &t.ExprID, &path, &t.Arg1, &t.Arg2, &args, &exactArgs, &t.Operation, &t.OperationTime, &leaseID, &leaseDeadline, &agentID); err != nil {
+			return nil, err
+		}
+		p, ok := byID[t.ExprID]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal([]byte(path), &t.Path); err != nil {
+			return nil, fmt.Errorf("повреждённый путь задачи %s: %v", t.ID, err)
+		}
+		if args.Valid {
+			if err := json.Unmarshal([]byte(args.String), &t.Args); err != nil {
+				return nil, fmt.Errorf("повреждённые аргументы задачи %s: %v", t.ID, err)
+			}
+		}
+		if exactArgs.Valid {
+			if err := json.Unmarshal([]byte(exactArgs.String), &t.ExactArgs); err != nil {
+				return nil, fmt.Errorf("повреждённые аргументы задачи %s: %v", t.ID, err)
+			}
+		}
+		if leaseID.Valid {
+			t.LeaseID = leaseID.String
+			t.LeaseDeadline = time.UnixMilli(leaseDeadline.Int64)
+			t.AgentID = agentID.String
+		}
+		p.Tasks = append(p.Tasks, &t)
+	}
+	return pending, taskRows.Err()
+}
+
+// Webhook — адрес, на который приходят уведомления о завершении всех
+// выражений пользователя
+type Webhook struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"-"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func InsertWebhook(h *Webhook) error {
+	_, err := DB.Exec(
+		`INSERT INTO webhooks (id, user_id, url, secret, created_at) VALUES (?, ?, ?, ?, ?)`,
+		h.ID, h.UserID, h.URL, h.Secret, h.CreatedAt,
+	)
+	return err
+}
+
+func GetWebhooksByUserID(userID string) ([]Webhook, error) {
+	rows, err := DB.Query(`SELECT id, user_id, url, secret, created_at FROM webhooks WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hooks []Webhook
+	for rows.Next() {
+		var h Webhook
+		if err := rows.Scan(&h.ID, &h.UserID, &h.URL, &h.Secret, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// DeleteWebhook удаляет webhook пользователя. Возвращает false, если такого нет.
+func DeleteWebhook(id, userID string) (bool, error) {
+	res, err := DB.Exec(`DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// WebhookDelivery — запись журнала доставки: одна попытка отправки уведомления
+type WebhookDelivery struct {
+	DeliveryID   string    `json:"delivery_id"`
+	WebhookID    string    `json:"webhook_id,omitempty"`
+	ExpressionID string    `json:"expression_id"`
+	URL          string    `json:"url"`
+	Attempt      int       `json:"attempt"`
+	StatusCode   int       `json:"status_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Success      bool      `json:"success"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func InsertWebhookDelivery(d *WebhookDelivery) error {
+	var webhookID, errMsg sql.NullString
+	var statusCode sql.NullInt64
+	if d.WebhookID != "" {
+		webhookID = sql.NullString{String: d.WebhookID, Valid: true}
+	}
+	if d.Error != "" {
+		errMsg = sql.NullString{String: d.Error, Valid: true}
+	}
+	if d.StatusCode != 0 {
+		statusCode = sql.NullInt64{Int64: int64(d.StatusCode), Valid: true}
+	}
+	_, err := DB.Exec(
+		`INSERT INTO webhook_deliveries (delivery_id, webhook_id, expression_id, url, attempt, status_code, error, success, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.DeliveryID, webhookID, d.ExpressionID, d.URL, d.Attempt, statusCode, errMsg, d.Success, d.CreatedAt,
+	)
+	return err
+}
+
+// GetWebhookDeliveriesByUserID возвращает последние попытки доставки по выражениям пользователя
+func GetWebhookDeliveriesByUserID(userID string, limit int) ([]WebhookDelivery, error) {
+	rows, err := DB.Query(
+		`SELECT d.delivery_id, d.webhook_id, d.expression_id, d.url, d.attempt, d.status_code, d.error, d.success, d.created_at
+		   FROM webhook_deliveries d
+		   JOIN expressions e ON e.id = d.expression_id
+		  WHERE e.user_id = ?
+		  ORDER BY d.id DESC
+		  LIMIT ?`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var (
+			d                 WebhookDelivery
+			webhookID, errMsg sql.NullString
+			statusCode        sql.NullInt64
+		)
+		if err := rows.Scan(&d.DeliveryID, &webhookID, &d.ExpressionID, &d.URL, &d.Attempt, &statusCode, &errMsg, &d.Success, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.WebhookID = webhookID.String
+		d.Error = errMsg.String
+		d.StatusCode = int(statusCode.Int64)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// UserSettings — настройки пользователя по умолчанию
+type UserSettings struct {
+	Format FormatOptions `json:"format"`
+}
+
+// GetUserSettings возвращает настройки пользователя; если их нет — пустые
+func GetUserSettings(userID string) (UserSettings, error) {
+	var (
+		settings UserSettings
+		format   sql.NullString
+	)
+	err := DB.QueryRow(`SELECT format FROM user_settings WHERE user_id = ?`, userID).Scan(&format)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if format.Valid {
+		if err := json.Unmarshal([]byte(format.String), &settings.Format); err != nil {
+			return settings, err
+		}
+	}
+	return settings, nil
+}
+
+func SaveUserSettings(userID string, settings UserSettings) error {
+	format, err := json.Marshal(settings.Format)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(
+		`INSERT INTO user_settings (user_id, format) VALUES (?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET format = excluded.format`,
+		userID, string(format),
+	)
+	return err
+}
+
+// UserVariable — переменная пользователя. В БД хранится точное значение.
+type UserVariable struct {
+	Name      string    `json:"name"`
+	Value     Number    `json:"value"`
+	Exact     string    `json:"exact"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func GetVariablesByUserID(userID string) ([]UserVariable, error) {
+	rows, err := DB.Query(`SELECT name, value, updated_at FROM variables WHERE user_id = ? ORDER BY name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var vars []UserVariable
+	for rows.Next() {
+		var v UserVariable
+		if err := rows.Scan(&v.Name, &v.Exact, &v.UpdatedAt); err != nil {
+			return nil, err
+		}
+		parsed, err := calculation.ParseVariable(v.Exact)
+		if err != nil {
+			return nil, fmt.Errorf("повреждённое значение переменной %s: %v", v.Name, err)
+		}
+		v.Value = parsed.Value
+		vars = append(vars, v)
+	}
+	return vars, rows.Err()
+}
+
+// SaveVariable создаёт или заменяет переменную пользователя
+func SaveVariable(userID string, v *UserVariable) error {
+	_, err := DB.Exec(
+		`INSERT INTO variables (user_id, name, value, updated_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(user_id, name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		userID, v.Name, v.Exact, v.UpdatedAt,
+	)
+	return err
+}
+
+// DeleteVariable удаляет переменную пользователя. Возвращает false, если такой нет.
+func DeleteVariable(userID, name string) (bool, error) {
+	res, err := DB.Exec(`DELETE FROM variables WHERE user_id = ? AND name = ?`, userID, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
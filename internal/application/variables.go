@@ -0,0 +1,133 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+// Переменные пользователя подставляются в выражение при отправке, вместе со
+// встроенными константами pi и e. Значения, которые получило выражение,
+// сохраняются в нём (поле variables), так что последующее изменение
+// переменной не влияет ни на него, ни на его пересчёт после перезапуска.
+
+// userVariables возвращает переменные пользователя для разбора выражения
+func userVariables(userID string) (map[string]Variable, error) {
+	list, err := GetVariablesByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]Variable, len(list))
+	for _, v := range list {
+		vars[v.Name] = Variable{Value: v.Value, Exact: v.Exact}
+	}
+	return vars, nil
+}
+
+// variableValue читает значение из запроса: число JSON или строку с числом
+// в записи выражения ("0xFF", "1e-9") либо дробью ("1/3")
+func variableValue(raw json.RawMessage) (Variable, error) {
+	text := string(raw)
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return Variable{}, err
+		}
+	}
+	return calculation.ParseVariable(text)
+}
+
+// variablesHandler обрабатывает /api/v1/variables и /api/v1/variables/{name}:
+// GET — список или одна переменная, PUT /{name} {"value": ...} — создать или
+// заменить, DELETE /{name} — удалить
+func (o *Orchestrator) variablesHandler(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		http.Error(w, "неверный Authorization header", http.StatusUnauthorized)
+		return
+	}
+	tokenStr := strings.TrimPrefix(auth, "Bearer ")
+	claims, err := ParseJWT(tokenStr)
+	if err != nil {
+		http.Error(w, "неверный токен", http.StatusUnauthorized)
+		return
+	}
+	userID := claims.UserID
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/variables"), "/")
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		vars, err := GetVariablesByUserID(userID)
+		if err != nil {
+			http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		if vars == nil {
+			vars = []UserVariable{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(vars)
+
+	case name != "" && r.Method == http.MethodGet:
+		vars, err := GetVariablesByUserID(userID)
+		if err != nil {
+			http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		for _, v := range vars {
+			if v.Name == name {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(v)
+				return
+			}
+		}
+		http.Error(w, "переменная не существует", http.StatusNotFound)
+
+	case name != "" && r.Method == http.MethodPut:
+		if err := calculation.CheckName(name); err != nil {
+			http.Error(w, "неверное имя: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "неверный JSON", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if len(req.Value) == 0 {
+			http.Error(w, "не задано значение", http.StatusBadRequest)
+			return
+		}
+		value, err := variableValue(req.Value)
+		if err != nil {
+			http.Error(w, "неверное значение: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		v := &UserVariable{Name: name, Value: value.Value, Exact: value.Exact, UpdatedAt: time.Now().UTC()}
+		if err := SaveVariable(userID, v); err != nil {
+			http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+
+	case name != "" && r.Method == http.MethodDelete:
+		ok, err := DeleteVariable(userID, name)
+		if err != nil {
+			http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "переменная не существует", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "метод не поддерживается", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,95 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func listVariables(t *testing.T, f *fixture, token string) []UserVariable {
+	t.Helper()
+	rec := serve(f.o.variablesHandler, http.MethodGet, "/api/v1/variables", "", userHeader(token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Ожидался код 200, получен %d", rec.Code)
+	}
+	var vars []UserVariable
+	if err := json.NewDecoder(rec.Body).Decode(&vars); err != nil {
+		t.Fatalf("Неверный ответ: %v", err)
+	}
+	return vars
+}
+
+func TestVariables_CRUD(t *testing.T) {
+	f := newFixture(t)
+	if vars := listVariables(t, f, f.token); len(vars) != 0 {
+		t.Fatalf("Ожидался пустой список, получено %+v", vars)
+	}
+
+	for _, tc := range []struct {
+		target, body string
+		code         int
+	}{
+		{"/api/v1/variables/rate", `{"value":"1/3"}`, http.StatusOK},
+		{"/api/v1/variables/x", `{"value":2.5}`, http.StatusOK},
+		{"/api/v1/variables/x", `{"value":4}`, http.StatusOK},
+		{"/api/v1/variables/pi", `{"value":3}`, http.StatusBadRequest},
+		{"/api/v1/variables/y", `{}`, http.StatusBadRequest},
+		{"/api/v1/variables/y", `{"value":"abc"}`, http.StatusBadRequest},
+	} {
+		if rec := serve(f.o.variablesHandler, http.MethodPut, tc.target, tc.body, userHeader(f.token)); rec.Code != tc.code {
+			t.Errorf("PUT %s %s: ожидался код %d, получен %d: %s", tc.target, tc.body, tc.code, rec.Code, rec.Body)
+		}
+	}
+
+	vars := listVariables(t, f, f.token)
+	if len(vars) != 2 {
+		t.Fatalf("Ожидались 2 переменные, получено %+v", vars)
+	}
+	rec := serve(f.o.variablesHandler, http.MethodGet, "/api/v1/variables/x", "", userHeader(f.token))
+	var x UserVariable
+	if err := json.NewDecoder(rec.Body).Decode(&x); err != nil || x.Name != "x" || x.Value != 4 {
+		t.Errorf("PUT должен заменить значение: %+v, %v", x, err)
+	}
+
+	// переменная подставляется в выражение
+	id := f.submit(`{"expression":"x*2"}`).Id
+	f.drain()
+	if status, result := f.status(id); status != 3 || result != "8" {
+		t.Errorf("Получено %d, %q", status, result)
+	}
+
+	if rec := serve(f.o.variablesHandler, http.MethodDelete, "/api/v1/variables/x", "", userHeader(f.token)); rec.Code != http.StatusNoContent {
+		t.Errorf("Ожидался код 204, получен %d", rec.Code)
+	}
+	if rec := serve(f.o.variablesHandler, http.MethodDelete, "/api/v1/variables/x", "", userHeader(f.token)); rec.Code != http.StatusNotFound {
+		t.Errorf("Повторное удаление: ожидался код 404, получен %d", rec.Code)
+	}
+	if rec := serve(f.o.variablesHandler, http.MethodGet, "/api/v1/variables/x", "", userHeader(f.token)); rec.Code != http.StatusNotFound {
+		t.Errorf("Ожидался код 404, получен %d", rec.Code)
+	}
+	if rec := serve(f.o.variablesHandler, http.MethodGet, "/api/v1/variables", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Без токена: ожидался код 401, получен %d", rec.Code)
+	}
+}
+
+func TestVariables_PerUser(t *testing.T) {
+	f := newFixture(t)
+	other := f.userToken("u2")
+	serve(f.o.variablesHandler, http.MethodPut, "/api/v1/variables/x", `{"value":1}`, userHeader(f.token))
+	serve(f.o.variablesHandler, http.MethodPut, "/api/v1/variables/x", `{"value":2}`, userHeader(other))
+	serve(f.o.variablesHandler, http.MethodPut, "/api/v1/variables/secret", `{"value":3}`, userHeader(other))
+
+	vars := listVariables(t, f, f.token)
+	if len(vars) != 1 || vars[0].Name != "x" || vars[0].Value != 1 {
+		t.Fatalf("Получены чужие переменные: %+v", vars)
+	}
+	if rec := serve(f.o.variablesHandler, http.MethodGet, "/api/v1/variables/secret", "", userHeader(f.token)); rec.Code != http.StatusNotFound {
+		t.Errorf("Чужая переменная: ожидался код 404, получен %d", rec.Code)
+	}
+	if rec := serve(f.o.variablesHandler, http.MethodDelete, "/api/v1/variables/secret", "", userHeader(f.token)); rec.Code != http.StatusNotFound {
+		t.Errorf("Удаление чужой переменной: ожидался код 404, получен %d", rec.Code)
+	}
+	if vars := listVariables(t, f, other); len(vars) != 2 {
+		t.Errorf("Переменные второго пользователя пропали: %+v", vars)
+	}
+}
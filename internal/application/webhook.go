@@ -0,0 +1,407 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Когда выражение переходит в конечный статус, оркестратор отправляет POST
+// с JSON-описанием результата на webhook_url из запроса и на все webhooks
+// пользователя. Тело подписывается HMAC-SHA256: секретом webhook или, для
+// адреса из запроса, общим WEBHOOK_SECRET. Неудачные попытки повторяются с
+// экспоненциальной задержкой, каждая попытка пишется в webhook_deliveries.
+//
+// Уведомления не уходят во внутреннюю сеть: адрес, который указывает на
+// loopback, частную или link-local сеть, отклоняется при добавлении, а
+// соединение с таким IP запрещено и при отправке — на случай, если имя
+// позже стало указывать на другой адрес. WEBHOOK_ALLOW_PRIVATE=true снимает
+// ограничение, например для локальной разработки.
+//
+// Повторы живут только в памяти: доставка не гарантируется, и при остановке
+// оркестратора ожидающие повтора уведомления отбрасываются. Журнал уже
+// сделанных попыток остаётся в webhook_deliveries.
+
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookEventFinished   = "expression.finished"
+)
+
+// WebhookTarget — куда и с каким секретом отправлять уведомление
+type WebhookTarget struct {
+	WebhookID string // пустой для webhook_url из запроса
+	URL       string
+	Secret    string
+}
+
+// WebhookPayload — тело уведомления о завершении выражения
+type WebhookPayload struct {
+	Event      string           `json:"event"`
+	ID         string           `json:"id"`
+	Expression string           `json:"expression"`
+	Status     string           `json:"status"`
+	Result     interface{}      `json:"result"`
+	Error      *ExpressionError `json:"error,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	DurationMs int64            `json:"duration_ms"`
+}
+
+// SignPayload возвращает значение заголовка X-Webhook-Signature для тела запроса
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSender доставляет уведомления с повторами
+type WebhookSender struct {
+	Client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+	// Record вызывается после каждой попытки, в том числе неудачной
+	Record func(WebhookDelivery)
+
+	initOnce sync.Once
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func newWebhookSender() *WebhookSender {
+	attempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	if err != nil || attempts <= 0 {
+		attempts = 5
+	}
+	ms, err := strconv.Atoi(os.Getenv("WEBHOOK_RETRY_BASE_MS"))
+	if err != nil || ms < 0 {
+		ms = 1000
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: checkWebhookDial}
+	return &WebhookSender{
+		Client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+		MaxAttempts: attempts,
+		BaseDelay:   time.Duration(ms) * time.Millisecond,
+		Record: func(d WebhookDelivery) {
+			if err := InsertWebhookDelivery(&d); err != nil {
+				log.Printf("Ошибка записи доставки webhook %s: %v", d.DeliveryID, err)
+			}
+		},
+	}
+}
+
+// Send отправляет payload, пока получатель не ответит 2xx или не кончатся
+// попытки. Задержка между попытками удваивается. Возвращает true при успехе.
+func (s *WebhookSender) Send(exprID string, target WebhookTarget, payload []byte) bool {
+	deliveryID, _ := generateRandomID(16)
+	delay := s.BaseDelay
+	for attempt := 1; attempt <= s.MaxAttempts; attempt++ {
+		code, err := s.post(target, deliveryID, payload)
+		ok := err == nil && code >= 200 && code < 300
+		if err == nil && !ok {
+			err = fmt.Errorf("получатель ответил %d", code)
+		}
+		if s.Record != nil {
+			d := WebhookDelivery{
+				DeliveryID:   deliveryID,
+				WebhookID:    target.WebhookID,
+				ExpressionID: exprID,
+				URL:          target.URL,
+				Attempt:      attempt,
+				StatusCode:   code,
+				Success:      ok,
+				CreatedAt:    time.Now().UTC(),
+			}
+			if err != nil {
+				d.Error = err.Error()
+			}
+			s.Record(d)
+		}
+		if ok {
+			return true
+		}
+		log.Printf("Webhook %s для выражения %s, попытка %d: %v", target.URL, exprID, attempt, err)
+		if attempt < s.MaxAttempts {
+			select {
+			case <-time.After(delay):
+			case <-s.stopped():
+				log.Printf("Webhook %s для выражения %s: повторы прерваны остановкой", target.URL, exprID)
+				return false
+			}
+			delay *= 2
+		}
+	}
+	return false
+}
+
+// Stop прерывает ожидание повторов во всех текущих и будущих вызовах Send
+func (s *WebhookSender) Stop() {
+	s.stopOnce.Do(func() { close(s.stopped()) })
+}
+
+// stopped возвращает канал, который закрывает Stop. Создаётся при первом
+// обращении, чтобы работал и WebhookSender, собранный без newWebhookSender.
+func (s *WebhookSender) stopped() chan struct{} {
+	s.initOnce.Do(func() { s.stop = make(chan struct{}) })
+	return s.stop
+}
+
+func (s *WebhookSender) post(target WebhookTarget, deliveryID string, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, target.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	if target.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignPayload(target.Secret, payload))
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook_url должен быть абсолютным http(s) адресом")
+	}
+	if allowPrivateWebhooks() {
+		return nil
+	}
+	host := u.Hostname()
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return fmt.Errorf("не удалось разрешить адрес webhook %s", host)
+		}
+		ips = ips[:0]
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	for _, ip := range ips {
+		if internalIP(ip) {
+			return fmt.Errorf("webhook %s указывает на внутренний адрес %s", host, ip)
+		}
+	}
+	return nil
+}
+
+// checkWebhookDial не даёт соединиться с внутренним адресом, даже если имя
+// из webhook стало указывать на него после проверки
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	if allowPrivateWebhooks() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+		return fmt.Errorf("соединение с внутренним адресом %s запрещено", host)
+	}
+	return nil
+}
+
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+func allowPrivateWebhooks() bool {
+	allow, _ := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE"))
+	return allow
+}
+
+// finish вызывается под o.mu, когда выражение перешло в конечный статус
+func (o *Orchestrator) finish(exprID string) {
+	if err := MarkExpressionFinished(exprID); err != nil {
+		log.Printf("Ошибка обновления выражения %s: %v", exprID, err)
+	}
+	o.goBackground(func() { o.notifyWebhooks(exprID) })
+	if len(o.dependents[exprID]) > 0 {
+		o.goBackground(func() { o.resolveDependents(exprID) })
+	}
+}
+
+// goBackground запускает горутину, которую дождётся Close
+func (o *Orchestrator) goBackground(fn func()) {
+	o.background.Add(1)
+	go func() {
+		defer o.background.Done()
+		fn()
+	}()
+}
+
+// Close прерывает повторы webhook и ждёт фоновые горутины. После него можно
+// закрывать БД.
+func (o *Orchestrator) Close() {
+	o.webhooks.Stop()
+	o.background.Wait()
+}
+
+func (o *Orchestrator) notifyWebhooks(exprID string) {
+	expr, err := GetExpressionByID(exprID)
+	if err != nil {
+		log.Printf("Webhook: выражение %s не найдено: %v", exprID, err)
+		return
+	}
+	var targets []WebhookTarget
+	if expr.WebhookURL != "" {
+		targets = append(targets, WebhookTarget{URL: expr.WebhookURL, Secret: os.Getenv("WEBHOOK_SECRET")})
+	}
+	hooks, err := GetWebhooksByUserID(expr.UserID)
+	if err != nil {
+		log.Printf("Ошибка чтения webhooks пользователя %s: %v", expr.UserID, err)
+	}
+	for _, h := range hooks {
+		targets = append(targets, WebhookTarget{WebhookID: h.ID, URL: h.URL, Secret: h.Secret})
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	expr.render(userFormat(expr.UserID))
+	payload, err := json.Marshal(newWebhookPayload(expr))
+	if err != nil {
+		log.Printf("Ошибка кодирования webhook выражения %s: %v", exprID, err)
+		return
+	}
+	for _, t := range targets {
+		o.goBackground(func() { o.webhooks.Send(exprID, t, payload) })
+	}
+}
+
+func newWebhookPayload(expr *FullExpression) WebhookPayload {
+	p := WebhookPayload{
+		Event:      WebhookEventFinished,
+		ID:         expr.ExpressionID,
+		Expression: expr.Expression,
+		Status:     getStatusName(expr.StatusID),
+		Error:      expr.Error,
+		CreatedAt:  expr.CreatedAt,
+	}
+	p.Result = expr.Result
+	if expr.FinishedAt != nil {
+		p.FinishedAt = *expr.FinishedAt
+		p.DurationMs = p.FinishedAt.Sub(p.CreatedAt).Milliseconds()
+	}
+	return p
+}
+
+// webhooksHandler обрабатывает /api/v1/webhooks и /api/v1/webhooks/...:
+// GET — список, POST {"url": ...} — добавить, DELETE /{id} — удалить,
+// GET /deliveries — журнал доставки
+func (o *Orchestrator) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		http.Error(w, "неверный Authorization header", http.StatusUnauthorized)
+		return
+	}
+	tokenStr := strings.TrimPrefix(auth, "Bearer ")
+	claims, err := ParseJWT(tokenStr)
+	if err != nil {
+		http.Error(w, "неверный токен", http.StatusUnauthorized)
+		return
+	}
+	userID := claims.UserID
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/webhooks"), "/")
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		hooks, err := GetWebhooksByUserID(userID)
+		if err != nil {
+			http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		// секрет показываем только при создании
+		for i := range hooks {
+			hooks[i].Secret = ""
+		}
+		if hooks == nil {
+			hooks = []Webhook{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hooks)
+
+	case rest == "" && r.Method == http.MethodPost:
+		var req struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "неверный JSON", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if err := validateWebhookURL(req.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, _ := generateRandomID(8)
+		secret, err := generateRandomID(32)
+		if err != nil {
+			http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		hook := &Webhook{ID: id, UserID: userID, URL: req.URL, Secret: secret, CreatedAt: time.Now().UTC()}
+		if err := InsertWebhook(hook); err != nil {
+			http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(hook)
+
+	case rest == "deliveries" && r.Method == http.MethodGet:
+		deliveries, err := GetWebhookDeliveriesByUserID(userID, 100)
+		if err != nil {
+			http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		if deliveries == nil {
+			deliveries = []WebhookDelivery{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveries)
+
+	case rest != "" && r.Method == http.MethodDelete:
+		ok, err := DeleteWebhook(rest, userID)
+		if err != nil {
+			http.Error(w, "ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "webhook не существует", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "метод не поддерживается", http.StatusMethodNotAllowed)
+	}
+}
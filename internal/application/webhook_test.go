@@ -0,0 +1,49 @@
+package application
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateWebhookURL_InternalAddresses(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "")
+	for _, raw := range []string{
+		"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://[::1]/hook",
+		"http://10.0.0.5/hook", "http://192.168.1.1/hook", "http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/hook", "http://[fe80::1]/hook", "ftp://93.184.216.34/hook",
+	} {
+		if validateWebhookURL(raw) == nil {
+			t.Errorf("%s: ожидалась ошибка", raw)
+		}
+	}
+	if err := validateWebhookURL("https://93.184.216.34/hook"); err != nil {
+		t.Errorf("Неожиданная ошибка: %v", err)
+	}
+
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "true")
+	if err := validateWebhookURL("http://127.0.0.1:8080/hook"); err != nil {
+		t.Errorf("WEBHOOK_ALLOW_PRIVATE: неожиданная ошибка: %v", err)
+	}
+}
+
+// адрес мог пройти проверку, а потом начать указывать внутрь сети
+func TestWebhookSender_RefusesInternalDial(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "")
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer receiver.Close()
+
+	sender := newWebhookSender()
+	sender.MaxAttempts = 1
+	var attempts []WebhookDelivery
+	sender.Record = func(d WebhookDelivery) { attempts = append(attempts, d) }
+	if sender.Send("e1", WebhookTarget{URL: receiver.URL}, []byte(`{}`)) {
+		t.Fatal("Доставка на loopback должна быть запрещена")
+	}
+	if calls != 0 || len(attempts) != 1 || attempts[0].Error == "" {
+		t.Errorf("Получено %d запросов, попытки %+v", calls, attempts)
+	}
+}
@@ -0,0 +1,378 @@
+package calculation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type ASTNode struct {
+	IsLeaf   bool       `json:"is_leaf"`
+	Value    float64    `json:"value"`
+	Operator string     `json:"operator,omitempty"`
+	Left     *ASTNode   `json:"left,omitempty"`
+	Right    *ASTNode   `json:"right,omitempty"`
+	Func     string     `json:"func,omitempty"`
+	Args     []*ASTNode `json:"args,omitempty"`
+	// Exact — точное значение листа для точного режима ("1/3")
+	Exact string `json:"exact,omitempty"`
+	// Literal — число так, как оно записано в выражении ("0xFF", "1_000")
+	Literal string `json:"literal,omitempty"`
+	// Var — имя переменной или константы, значение которой стоит в листе
+	Var string `json:"var,omitempty"`
+	// Ref — ID выражения, на результат которого ссылается узел (см. refs.go)
+	Ref string `json:"ref,omitempty"`
+}
+
+// MarshalJSON записывает Value через Number, чтобы дерево с NaN или
+// бесконечностью в листе можно было сохранить
+func (n *ASTNode) MarshalJSON() ([]byte, error) {
+	type plain ASTNode
+	return json.Marshal(struct {
+		*plain
+		Value Number `json:"value"`
+	}{(*plain)(n), Number(n.Value)})
+}
+
+func (n *ASTNode) UnmarshalJSON(data []byte) error {
+	type plain ASTNode
+	aux := struct {
+		*plain
+		Value Number `json:"value"`
+	}{plain: (*plain)(n)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	n.Value = float64(aux.Value)
+	return nil
+}
+
+// Children возвращает потомков узла: аргументы вызова функции, операнд
+// унарного минуса либо левый и правый операнды бинарного оператора
+func (n *ASTNode) Children() []*ASTNode {
+	switch {
+	case n.Func != "":
+		return n.Args
+	case n.Left == nil && n.Right == nil:
+		return nil
+	case n.Right == nil:
+		return []*ASTNode{n.Left}
+	default:
+		return []*ASTNode{n.Left, n.Right}
+	}
+}
+
+// String возвращает каноническую запись дерева: все операции в скобках,
+// числа в кратчайшей точной форме. Одинаковые по смыслу записи ("(1+2)*3",
+// "((1 + 2)) * 3.0") дают одну и ту же строку.
+func (n *ASTNode) String() string {
+	var b strings.Builder
+	n.writeTo(&b, false)
+	return b.String()
+}
+
+// ExactString — то же для точного режима: числа записываются несократимыми
+// дробями, поэтому 0.1 и 0.1000000000000000001 различаются
+func (n *ASTNode) ExactString() string {
+	var b strings.Builder
+	n.writeTo(&b, true)
+	return b.String()
+}
+
+func (n *ASTNode) writeTo(b *strings.Builder, exact bool) {
+	switch {
+	case n == nil:
+		b.WriteString("?")
+	case n.IsLeaf && exact:
+		b.WriteString(n.exactLiteral())
+	case n.IsLeaf:
+		b.WriteString(strconv.FormatFloat(n.Value, 'g', -1, 64))
+	case n.Ref != "":
+		b.WriteString(n.refText())
+	case n.Func != "":
+		b.WriteString(n.Func)
+		b.WriteByte('(')
+		for i, arg := range n.Args {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			arg.writeTo(b, exact)
+		}
+		b.WriteByte(')')
+	case n.Operator == "neg":
+		b.WriteString("(-")
+		n.Left.writeTo(b, exact)
+		b.WriteByte(')')
+	default:
+		b.WriteByte('(')
+		n.Left.writeTo(b, exact)
+		b.WriteString(n.Operator)
+		n.Right.writeTo(b, exact)
+		b.WriteByte(')')
+	}
+}
+
+// SyntaxError описывает ошибку разбора: позицию (номер символа исходной
+// строки, с нуля), что ожидалось и что встретилось на самом деле
+type SyntaxError struct {
+	Expression string
+	Position   int
+	Expected   string
+	Found      string
+	Message    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d: expected %s, found %s", e.Message, e.Position, e.Expected, e.Found)
+}
+
+// Snippet возвращает выражение с кареткой под ошибочным символом:
+//
+//	3+*2
+//	  ^
+func (e *SyntaxError) Snippet() string {
+	const window = 40
+	runes := []rune(e.Expression)
+	start, end := 0, len(runes)
+	prefix, suffix := "", ""
+	if e.Position > window {
+		start = e.Position - window
+		prefix = "..."
+	}
+	if end-e.Position > window {
+		end = e.Position + window
+		suffix = "..."
+	}
+	line := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, string(runes[start:end]))
+	caret := strings.Repeat(" ", utf8.RuneCountInString(prefix)+e.Position-start) + "^"
+	return prefix + line + suffix + "\n" + caret
+}
+
+type parser struct {
+	input string
+	pos   int
+	vars  map[string]Variable
+}
+
+// ParseAST разбирает выражение в дерево; из имён доступны только встроенные
+// константы. Ошибки разбора имеют тип *SyntaxError.
+func ParseAST(expr string) (*ASTNode, error) {
+	return ParseASTWith(expr, nil)
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek пропускает пробелы и возвращает текущий символ, 0 — конец строки
+func (p *parser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) errorf(expected, message string) *SyntaxError {
+	return p.errorAt(p.pos, expected, message)
+}
+
+func (p *parser) errorAt(pos int, expected, message string) *SyntaxError {
+	found := "end of input"
+	if pos < len(p.input) {
+		r, _ := utf8.DecodeRuneInString(p.input[pos:])
+		found = fmt.Sprintf("%q", r)
+	}
+	return &SyntaxError{
+		Expression: p.input,
+		Position:   utf8.RuneCountInString(p.input[:pos]),
+		Expected:   expected,
+		Found:      found,
+		Message:    message,
+	}
+}
+
+func (p *parser) parseExpression() (*ASTNode, error) {
+	return p.parseBinaryOp(p.parseTerm, []string{"+", "-"})
+}
+
+func (p *parser) parseTerm() (*ASTNode, error) {
+	return p.parseBinaryOp(p.parseUnary, []string{"*", "/"})
+}
+
+// parseUnary разбирает префиксный знак. Минус превращается в узел neg, плюс
+// ничего не меняет. Знак допустим везде, где ожидается операнд, и знаки
+// могут идти подряд: 3+-2, 4 - - 1, --2, 2*-(4), max(1,-2).
+func (p *parser) parseUnary() (*ASTNode, error) {
+	sign := p.peek()
+	if sign != '+' && sign != '-' {
+		return p.parsePower()
+	}
+	p.pos++
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if sign == '+' {
+		return operand, nil
+	}
+	return &ASTNode{Operator: "neg", Left: operand}, nil
+}
+
+// parsePower разбирает возведение в степень. Оператор ^ правоассоциативный:
+// 2^3^2 = 2^(3^2), поэтому правый операнд разбирается рекурсивно.
+// Унарный минус связывает слабее: -2^2 = -(2^2).
+func (p *parser) parsePower() (*ASTNode, error) {
+	base, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != '^' {
+		return base, nil
+	}
+	p.pos++
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &ASTNode{Operator: "^", Left: base, Right: exponent}, nil
+}
+
+func (p *parser) parseBinaryOp(next func() (*ASTNode, error), ops []string) (*ASTNode, error) {
+	node, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		c := p.peek()
+		if c == 0 {
+			break
+		}
+		matched := ""
+		for _, op := range ops {
+			if string(c) == op {
+				matched = op
+				break
+			}
+		}
+		if matched == "" {
+			break
+		}
+		p.pos++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		node = &ASTNode{Operator: matched, Left: node, Right: right}
+	}
+	return node, nil
+}
+
+func (p *parser) parseFactor() (*ASTNode, error) {
+	c := p.peek()
+	if c == '(' {
+		p.pos++
+		node, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf(")", "missing closing parenthesis")
+		}
+		p.pos++
+		return node, nil
+	}
+	if isLetter(c) {
+		return p.parseName()
+	}
+	if c == '$' {
+		return p.parseRef()
+	}
+	if !isDigit(c) && c != '.' {
+		return nil, p.errorf("number", "operand expected")
+	}
+	return p.parseNumber()
+}
+
+// parseNumber разбирает числовой литерал, см. lexer.go
+func (p *parser) parseNumber() (*ASTNode, error) {
+	start := p.pos
+	text := p.input[start : start+scanNumber(p.input[start:])]
+	r, nerr := parseNumber(text)
+	if nerr != nil {
+		return nil, p.errorAt(start+nerr.offset, nerr.expected, fmt.Sprintf("invalid number %q: %s", text, nerr.message))
+	}
+	val, ok := numberValue(r)
+	if !ok {
+		return nil, p.errorAt(start, "number", fmt.Sprintf("number %q is out of range", text))
+	}
+	p.pos += len(text)
+	return &ASTNode{IsLeaf: true, Value: val, Exact: r.RatString(), Literal: text}, nil
+}
+
+// parseName разбирает имя: вызов функции, если это функция или за именем
+// идёт скобка, иначе переменную или константу
+func (p *parser) parseName() (*ASTNode, error) {
+	start := p.pos
+	for p.pos < len(p.input) && isNameChar(p.input[p.pos]) {
+		p.pos++
+	}
+	name := p.input[start:p.pos]
+	if IsFunc(name) || p.peek() == '(' {
+		return p.parseCall(start, name)
+	}
+	if name == RefLast {
+		return &ASTNode{Ref: RefLast}, nil
+	}
+	v, ok := p.lookup(name)
+	if !ok {
+		return nil, p.errorAt(start, "number, variable or function", fmt.Sprintf("unknown name %q", name))
+	}
+	return &ASTNode{IsLeaf: true, Value: float64(v.Value), Exact: v.Exact, Var: name}, nil
+}
+
+// parseCall разбирает вызов встроенной функции: имя(аргумент, аргумент, ...)
+func (p *parser) parseCall(start int, name string) (*ASTNode, error) {
+	if !IsFunc(name) {
+		return nil, p.errorAt(start, "function name", fmt.Sprintf("unknown function %q", name))
+	}
+	if p.peek() != '(' {
+		return nil, p.errorf("(", fmt.Sprintf("missing arguments of %s", name))
+	}
+	p.pos++
+	var args []*ASTNode
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	if p.peek() != ')' {
+		return nil, p.errorf(", or )", "missing closing parenthesis")
+	}
+	p.pos++
+	if err := CheckArity(name, len(args)); err != nil {
+		return nil, p.errorAt(start, "matching number of arguments", err.Error())
+	}
+	return &ASTNode{Func: name, Args: args}, nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
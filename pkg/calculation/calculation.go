@@ -2,10 +2,47 @@ package calculation
 
 import (
 	"fmt"
+	"math"
 )
 
+// Calc вычисляет выражение локально и синхронно, без оркестратора и агентов.
+// Грамматика та же, что у ParseAST, а каждый узел считается теми же Compute и
+// ComputeFunc, что и у агентов, поэтому результат совпадает до бита.
 func Calc(expression string) (float64, error) {
-	return 0, fmt.Errorf("not implemented")
+	ast, err := ParseAST(expression)
+	if err != nil {
+		return 0, err
+	}
+	return Eval(ast)
+}
+
+// Eval вычисляет уже разобранное дерево
+func Eval(n *ASTNode) (float64, error) {
+	if n.IsLeaf {
+		return n.Value, nil
+	}
+	if n.Ref != "" {
+		return 0, refError(n)
+	}
+	children := n.Children()
+	args := make([]float64, len(children))
+	for i, c := range children {
+		v, err := Eval(c)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	switch {
+	case n.Func != "":
+		return ComputeFunc(n.Func, args)
+	case n.Operator == "neg":
+		return -args[0], nil
+	case len(args) == 2:
+		return Compute(n.Operator, args[0], args[1])
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrInvalidOperator, n.Operator)
+	}
 }
 
 func Compute(operation string, a, b float64) (float64, error) {
@@ -21,7 +58,20 @@ func Compute(operation string, a, b float64) (float64, error) {
 			return 0, ErrDivisionByZero
 		}
 		return a / b, nil
+	case "^":
+		return power(a, b)
 	default:
-		return 0, fmt.Errorf("invalid operator: %s", operation)
+		return 0, fmt.Errorf("%w: %s", ErrInvalidOperator, operation)
+	}
+}
+
+func power(a, b float64) (float64, error) {
+	if a == 0 && b < 0 {
+		return 0, ErrDivisionByZero
+	}
+	if a < 0 && b != math.Trunc(b) {
+		// вещественного корня из отрицательного числа нет
+		return 0, fmt.Errorf("%w: negative base %v with fractional exponent %v", ErrDomain, a, b)
 	}
+	return math.Pow(a, b), nil
 }
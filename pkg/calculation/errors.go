@@ -3,5 +3,38 @@ package calculation
 import "errors"
 
 var (
-	ErrDivisionByZero      = errors.New("division by zero")
+	ErrDivisionByZero  = errors.New("division by zero")
+	ErrInvalidOperator = errors.New("invalid operator")
+	ErrDomain          = errors.New("argument out of domain")
+	ErrArgumentCount   = errors.New("wrong number of arguments")
+	ErrInexact         = errors.New("result is not exact")
+	ErrReference       = errors.New("unresolved reference")
 )
+
+// Коды ошибок, которыми агент сообщает оркестратору о неудачном вычислении
+const (
+	CodeDivisionByZero  = "division_by_zero"
+	CodeInvalidOperator = "invalid_operator"
+	CodeDomain          = "domain_error"
+	CodeArgumentCount   = "argument_count"
+	CodeInexact         = "inexact"
+	CodeUnknown         = "unknown"
+)
+
+// ErrorCode возвращает код ошибки вычисления для передачи по сети
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrDivisionByZero):
+		return CodeDivisionByZero
+	case errors.Is(err, ErrInvalidOperator):
+		return CodeInvalidOperator
+	case errors.Is(err, ErrDomain):
+		return CodeDomain
+	case errors.Is(err, ErrArgumentCount):
+		return CodeArgumentCount
+	case errors.Is(err, ErrInexact):
+		return CodeInexact
+	default:
+		return CodeUnknown
+	}
+}
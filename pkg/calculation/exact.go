@@ -0,0 +1,166 @@
+package calculation
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Точный режим: значения — рациональные числа big.Rat, которые передаются
+// строками вида "3", "-1/3" или "0.25". Доступны арифметика, возведение в
+// целую степень и функции abs, min, max; у остальных функций результат в
+// общем случае иррационален.
+
+// maxExactBits ограничивает размер числителя и знаменателя степени, чтобы
+// 10^10^9 не съело всю память
+const maxExactBits = 1 << 20
+
+var exactFuncs = map[string]bool{"abs": true, "min": true, "max": true}
+
+// CheckExact проверяет, что выражение можно вычислить в точном режиме
+func CheckExact(n *ASTNode) error {
+	if n == nil {
+		return nil
+	}
+	if n.Func != "" && !exactFuncs[n.Func] {
+		return fmt.Errorf("%w: function %s", ErrInexact, n.Func)
+	}
+	if n.IsLeaf && n.Var != "" && n.Exact == "" {
+		return fmt.Errorf("%w: constant %s", ErrInexact, n.Var)
+	}
+	for _, c := range n.Children() {
+		if err := CheckExact(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ComputeExact — аналог Compute и ComputeFunc для точного режима
+func ComputeExact(operation string, args []string) (string, error) {
+	vals := make([]*big.Rat, len(args))
+	for i, s := range args {
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return "", fmt.Errorf("invalid operand %q", s)
+		}
+		vals[i] = r
+	}
+	res, err := computeExact(operation, vals)
+	if err != nil {
+		return "", err
+	}
+	return res.RatString(), nil
+}
+
+func computeExact(operation string, v []*big.Rat) (*big.Rat, error) {
+	if IsFunc(operation) {
+		if !exactFuncs[operation] {
+			return nil, fmt.Errorf("%w: function %s", ErrInexact, operation)
+		}
+		if err := CheckArity(operation, len(v)); err != nil {
+			return nil, err
+		}
+	} else if len(v) != 2 {
+		return nil, fmt.Errorf("%w: %s expects 2, got %d", ErrArgumentCount, operation, len(v))
+	}
+	res := new(big.Rat)
+	switch operation {
+	case "+":
+		return res.Add(v[0], v[1]), nil
+	case "-":
+		return res.Sub(v[0], v[1]), nil
+	case "*":
+		return res.Mul(v[0], v[1]), nil
+	case "/":
+		if v[1].Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return res.Quo(v[0], v[1]), nil
+	case "^":
+		return powExact(v[0], v[1])
+	case "abs":
+		return res.Abs(v[0]), nil
+	case "min", "max":
+		res.Set(v[0])
+		for _, a := range v[1:] {
+			if c := a.Cmp(res); (operation == "min" && c < 0) || (operation == "max" && c > 0) {
+				res.Set(a)
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrInvalidOperator, operation)
+}
+
+func powExact(a, b *big.Rat) (*big.Rat, error) {
+	if !b.IsInt() {
+		return nil, fmt.Errorf("%w: fractional exponent %s", ErrInexact, b.RatString())
+	}
+	if a.Sign() == 0 {
+		if b.Sign() < 0 {
+			return nil, ErrDivisionByZero
+		}
+		if b.Sign() == 0 {
+			return big.NewRat(1, 1), nil
+		}
+		return new(big.Rat), nil
+	}
+	e := new(big.Int).Abs(b.Num())
+	bits := max(a.Num().BitLen(), a.Denom().BitLen())
+	if !e.IsInt64() || e.Int64()*int64(bits) > maxExactBits {
+		return nil, fmt.Errorf("%w: result of %s^%s is too large", ErrDomain, a.RatString(), b.RatString())
+	}
+	num := new(big.Int).Exp(a.Num(), e, nil)
+	den := new(big.Int).Exp(a.Denom(), e, nil)
+	if b.Sign() < 0 {
+		num, den = den, num
+	}
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+// NegExact меняет знак точного значения
+func NegExact(s string) string {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return s
+	}
+	return r.Neg(r).RatString()
+}
+
+// ExactFloat возвращает ближайшее к точному значению число float64
+func ExactFloat(s string) (float64, bool) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, false
+	}
+	f, _ := r.Float64()
+	return f, true
+}
+
+// FormatExact записывает точное значение дробью ("1/3") или, если digits >= 0,
+// десятичной дробью с digits знаками после запятой (с округлением)
+func FormatExact(s string, digits int) (string, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return "", fmt.Errorf("invalid exact value %q", s)
+	}
+	if digits < 0 {
+		return r.RatString(), nil
+	}
+	return r.FloatString(digits), nil
+}
+
+// exactLiteral — точное значение листа: текст числа из выражения или, если
+// его нет, значение float64
+func (n *ASTNode) exactLiteral() string {
+	if n.Exact != "" {
+		if r, ok := new(big.Rat).SetString(n.Exact); ok {
+			return r.RatString()
+		}
+	}
+	if r := new(big.Rat).SetFloat64(n.Value); r != nil {
+		return r.RatString()
+	}
+	return strconv.FormatFloat(n.Value, 'g', -1, 64)
+}
@@ -0,0 +1,245 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Нотации и режимы округления FormatOptions
+const (
+	NotationAuto       = "auto"
+	NotationFixed      = "fixed"
+	NotationScientific = "scientific"
+
+	RoundHalfEven = "half_even"
+	RoundHalfUp   = "half_up"
+	RoundHalfDown = "half_down"
+	RoundDown     = "down" // к нулю
+	RoundUp       = "up"   // от нуля
+	RoundFloor    = "floor"
+	RoundCeiling  = "ceiling"
+
+	MaxFormatDigits = 1000
+)
+
+// Запись NaN и бесконечностей: в JSON для них нет чисел
+const (
+	TextNaN     = "NaN"
+	TextInf     = "Infinity"
+	TextNegInf  = "-Infinity"
+	autoSciLow  = -7 // как в JavaScript: 1e-7 уже в экспоненциальной записи
+	autoSciHigh = 21
+)
+
+// FormatOptions — как записывать результат. Пустые поля — значения по умолчанию:
+// кратчайшая точная запись, нотация auto, округление half_even.
+type FormatOptions struct {
+	// Precision — знаков после запятой (в научной нотации — у мантиссы)
+	Precision *int `json:"precision,omitempty"`
+	// Significant — значащих цифр; нельзя задать вместе с Precision
+	Significant *int   `json:"significant,omitempty"`
+	Notation    string `json:"notation,omitempty"`
+	Rounding    string `json:"rounding,omitempty"`
+}
+
+// IsZero сообщает, что ни одна настройка не задана
+func (f FormatOptions) IsZero() bool {
+	return f.Precision == nil && f.Significant == nil && f.Notation == "" && f.Rounding == ""
+}
+
+// Merge дополняет настройки незаданными полями из defaults
+func (f FormatOptions) Merge(defaults FormatOptions) FormatOptions {
+	if f.Precision == nil && f.Significant == nil {
+		f.Precision, f.Significant = defaults.Precision, defaults.Significant
+	}
+	if f.Notation == "" {
+		f.Notation = defaults.Notation
+	}
+	if f.Rounding == "" {
+		f.Rounding = defaults.Rounding
+	}
+	return f
+}
+
+// Validate проверяет настройки
+func (f FormatOptions) Validate() error {
+	if f.Precision != nil && f.Significant != nil {
+		return errors.New("precision and significant are mutually exclusive")
+	}
+	if f.Precision != nil && (*f.Precision < 0 || *f.Precision > MaxFormatDigits) {
+		return fmt.Errorf("precision must be from 0 to %d", MaxFormatDigits)
+	}
+	if f.Significant != nil && (*f.Significant < 1 || *f.Significant > MaxFormatDigits) {
+		return fmt.Errorf("significant must be from 1 to %d", MaxFormatDigits)
+	}
+	switch f.Notation {
+	case "", NotationAuto, NotationFixed, NotationScientific:
+	default:
+		return fmt.Errorf("unknown notation %q", f.Notation)
+	}
+	switch f.Rounding {
+	case "", RoundHalfEven, RoundHalfUp, RoundHalfDown, RoundDown, RoundUp, RoundFloor, RoundCeiling:
+	default:
+		return fmt.Errorf("unknown rounding mode %q", f.Rounding)
+	}
+	return nil
+}
+
+// Format записывает число по настройкам. Конечные числа записываются так,
+// что результат — корректное число JSON; NaN и бесконечности — TextNaN,
+// TextInf и TextNegInf.
+func Format(v float64, opts FormatOptions) string {
+	switch {
+	case math.IsNaN(v):
+		return TextNaN
+	case math.IsInf(v, 1):
+		return TextInf
+	case math.IsInf(v, -1):
+		return TextNegInf
+	}
+	if opts.Precision != nil || opts.Significant != nil {
+		return FormatRat(new(big.Rat).SetFloat64(v), opts)
+	}
+	// без точности — кратчайшая запись, однозначно возвращающая то же число
+	switch opts.Notation {
+	case NotationFixed:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case NotationScientific:
+		return strconv.FormatFloat(v, 'e', -1, 64)
+	}
+	if abs := math.Abs(v); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.FormatFloat(v, 'e', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// FormatRat — Format для точного значения. Округление выполняется точно,
+// без промежуточного float64.
+func FormatRat(r *big.Rat, opts FormatOptions) string {
+	if opts.Precision == nil && opts.Significant == nil {
+		if r.IsInt() && opts.Notation != NotationScientific {
+			return r.RatString()
+		}
+		f, _ := r.Float64()
+		return Format(f, opts)
+	}
+	exp := decimalExponent(r)
+	scientific := opts.Notation == NotationScientific ||
+		(opts.Notation != NotationFixed && opts.Significant != nil && (exp < autoSciLow || exp >= autoSciHigh))
+
+	var scale int // знаков после запятой, может быть отрицательным
+	switch {
+	case opts.Significant != nil:
+		scale = *opts.Significant - 1 - exp
+	case scientific:
+		scale = *opts.Precision - exp
+	default:
+		scale = *opts.Precision
+	}
+	n := roundScaled(r, scale, opts.Rounding)
+	// 9.99 -> 10.0: цифр стало на одну больше
+	if opts.Significant != nil || scientific {
+		if len(new(big.Int).Abs(n).String()) > scale+exp+1 && n.Sign() != 0 {
+			exp++
+			scale--
+			n = roundScaled(r, scale, opts.Rounding)
+		}
+	}
+	sign := ""
+	if r.Sign() < 0 {
+		sign = "-"
+	}
+	digits := new(big.Int).Abs(n).String()
+	if scientific {
+		if n.Sign() == 0 {
+			digits = strings.Repeat("0", scale+exp+1)
+		}
+		mantissa := digits[:1]
+		if len(digits) > 1 {
+			mantissa += "." + digits[1:]
+		}
+		return fmt.Sprintf("%s%se%+d", sign, mantissa, exp)
+	}
+	return sign + placePoint(digits, scale)
+}
+
+// placePoint ставит запятую так, чтобы после неё было scale цифр
+func placePoint(digits string, scale int) string {
+	if scale <= 0 {
+		if digits == "0" {
+			return digits
+		}
+		return digits + strings.Repeat("0", -scale)
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// decimalExponent возвращает e: 10^e <= |r| < 10^(e+1); для нуля — 0
+func decimalExponent(r *big.Rat) int {
+	if r.Sign() == 0 {
+		return 0
+	}
+	abs := new(big.Rat).Abs(r)
+	e := len(abs.Num().String()) - len(abs.Denom().String())
+	for abs.Cmp(pow10(e)) < 0 {
+		e--
+	}
+	for abs.Cmp(pow10(e+1)) >= 0 {
+		e++
+	}
+	return e
+}
+
+func pow10(e int) *big.Rat {
+	p := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(absInt(e))), nil)
+	if e < 0 {
+		return new(big.Rat).SetFrac(big.NewInt(1), p)
+	}
+	return new(big.Rat).SetInt(p)
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// roundScaled округляет r*10^scale до целого по режиму mode
+func roundScaled(r *big.Rat, scale int, mode string) *big.Int {
+	x := new(big.Rat).Mul(r, pow10(scale))
+	q, m := new(big.Int).QuoRem(x.Num(), x.Denom(), new(big.Int))
+	if m.Sign() == 0 {
+		return q
+	}
+	away := false
+	half := new(big.Int).Abs(m)
+	half.Lsh(half, 1)
+	cmp := half.Cmp(x.Denom())
+	switch mode {
+	case RoundDown:
+	case RoundUp:
+		away = true
+	case RoundFloor:
+		away = x.Sign() < 0
+	case RoundCeiling:
+		away = x.Sign() > 0
+	case RoundHalfUp:
+		away = cmp >= 0
+	case RoundHalfDown:
+		away = cmp > 0
+	default:
+		away = cmp > 0 || (cmp == 0 && q.Bit(0) == 1)
+	}
+	if away {
+		q.Add(q, big.NewInt(int64(x.Sign())))
+	}
+	return q
+}
@@ -0,0 +1,80 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+)
+
+type function struct {
+	minArgs int
+	maxArgs int // -1 — без ограничения
+	apply   func(args []float64) (float64, error)
+}
+
+var functions = map[string]function{
+	"sqrt": {1, 1, func(args []float64) (float64, error) {
+		if args[0] < 0 {
+			return 0, fmt.Errorf("%w: sqrt of negative number %v", ErrDomain, args[0])
+		}
+		return math.Sqrt(args[0]), nil
+	}},
+	"sin": {1, 1, unary(math.Sin)},
+	"cos": {1, 1, unary(math.Cos)},
+	"log": {1, 1, func(args []float64) (float64, error) {
+		if args[0] <= 0 {
+			return 0, fmt.Errorf("%w: log of non-positive number %v", ErrDomain, args[0])
+		}
+		return math.Log(args[0]), nil
+	}},
+	"exp": {1, 1, unary(math.Exp)},
+	"abs": {1, 1, unary(math.Abs)},
+	"min": {1, -1, func(args []float64) (float64, error) {
+		res := args[0]
+		for _, a := range args[1:] {
+			res = math.Min(res, a)
+		}
+		return res, nil
+	}},
+	"max": {1, -1, func(args []float64) (float64, error) {
+		res := args[0]
+		for _, a := range args[1:] {
+			res = math.Max(res, a)
+		}
+		return res, nil
+	}},
+}
+
+func unary(f func(float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		return f(args[0]), nil
+	}
+}
+
+// IsFunc сообщает, есть ли встроенная функция с таким именем
+func IsFunc(name string) bool {
+	_, ok := functions[name]
+	return ok
+}
+
+// CheckArity проверяет, что функции передано допустимое число аргументов
+func CheckArity(name string, n int) error {
+	f, ok := functions[name]
+	if !ok {
+		return fmt.Errorf("%w: unknown function %s", ErrInvalidOperator, name)
+	}
+	if n < f.minArgs || (f.maxArgs >= 0 && n > f.maxArgs) {
+		if f.minArgs == f.maxArgs {
+			return fmt.Errorf("%w: %s expects %d, got %d", ErrArgumentCount, name, f.minArgs, n)
+		}
+		return fmt.Errorf("%w: %s expects at least %d, got %d", ErrArgumentCount, name, f.minArgs, n)
+	}
+	return nil
+}
+
+// ComputeFunc вычисляет встроенную функцию — аналог Compute для вызовов вида sqrt(x), max(a, b, c)
+func ComputeFunc(name string, args []float64) (float64, error) {
+	if err := CheckArity(name, len(args)); err != nil {
+		return 0, err
+	}
+	return functions[name].apply(args)
+}
@@ -0,0 +1,171 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Числовые литералы:
+//
+//	42  3.14  .5  1e-9  6.02E23     десятичные, с экспонентой
+//	0xFF  0b1010  0o17              целые шестнадцатеричные, двоичные, восьмеричные
+//	1_000_000  0xFF_FF              _ между цифрами для читаемости
+//
+// Литерал читается целиком (цифры, буквы, _, точки), а потом проверяется,
+// поэтому 1.2.3 или 0b102 дают ошибку с позицией неверного символа, а не
+// разбираются как 1.2 и непонятный остаток.
+
+// maxLiteralExponent ограничивает экспоненту: 1e1000000000 в точном режиме
+// потребовал бы гигабайты
+const maxLiteralExponent = 1000
+
+// numberError — ошибка в литерале: смещение от начала литерала, что ожидалось, что не так
+type numberError struct {
+	offset   int
+	expected string
+	message  string
+}
+
+// scanNumber возвращает длину литерала, начинающегося с s[0]
+func scanNumber(s string) int {
+	end := 0
+	hex := len(s) > 1 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
+	for end < len(s) {
+		c := s[end]
+		if isDigit(c) || isLetter(c) || c == '_' || c == '.' {
+			end++
+			continue
+		}
+		// знак экспоненты: 1e-9 (в 0x1e+5 это сложение)
+		if (c == '+' || c == '-') && !hex && end > 0 && (s[end-1] == 'e' || s[end-1] == 'E') {
+			end++
+			continue
+		}
+		break
+	}
+	return end
+}
+
+// parseNumber проверяет литерал и возвращает его точное значение
+func parseNumber(text string) (*big.Rat, *numberError) {
+	var nerr *numberError
+	if len(text) > 1 && text[0] == '0' && strings.ContainsRune("xXbBoO", rune(text[1])) {
+		nerr = checkBased(text)
+	} else {
+		nerr = checkDecimal(text)
+	}
+	if nerr != nil {
+		return nil, nerr
+	}
+	r, ok := new(big.Rat).SetString(strings.ReplaceAll(text, "_", ""))
+	if !ok {
+		return nil, &numberError{0, "number", "malformed number"}
+	}
+	return r, nil
+}
+
+func checkBased(text string) *numberError {
+	base, name := 16, "hexadecimal digit"
+	switch text[1] {
+	case 'b', 'B':
+		base, name = 2, "binary digit"
+	case 'o', 'O':
+		base, name = 8, "octal digit"
+	}
+	if len(text) == 2 {
+		return &numberError{2, name, "missing digits after " + text}
+	}
+	for i := 2; i < len(text); i++ {
+		c := text[i]
+		if c == '_' {
+			if i == 2 || i == len(text)-1 || text[i+1] == '_' {
+				return &numberError{i, name, "_ must separate digits"}
+			}
+			continue
+		}
+		if digitValue(c) >= base {
+			return &numberError{i, name, "invalid " + name}
+		}
+	}
+	return nil
+}
+
+func checkDecimal(text string) *numberError {
+	digits, dot, exp := 0, -1, -1
+	expDigits := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case isDigit(c):
+			if exp >= 0 {
+				expDigits++
+			} else {
+				digits++
+			}
+		case c == '_':
+			if i == 0 || !isDigit(text[i-1]) || i == len(text)-1 || !isDigit(text[i+1]) {
+				return &numberError{i, "digit", "_ must separate digits"}
+			}
+		case c == '.':
+			if dot >= 0 {
+				return &numberError{i, "digit or operator", "second decimal point in number"}
+			}
+			if exp >= 0 {
+				return &numberError{i, "digit", "decimal point in exponent"}
+			}
+			dot = i
+		case c == 'e' || c == 'E':
+			if exp >= 0 {
+				return &numberError{i, "digit or operator", "second exponent in number"}
+			}
+			if digits == 0 {
+				return &numberError{i, "digit", "missing digits before exponent"}
+			}
+			exp = i
+			if i+1 < len(text) && (text[i+1] == '+' || text[i+1] == '-') {
+				i++
+			}
+		default:
+			return &numberError{i, "digit or operator", fmt.Sprintf("unexpected %q in number", c)}
+		}
+	}
+	if digits == 0 {
+		return &numberError{0, "digit", "missing digits"}
+	}
+	if exp >= 0 {
+		if expDigits == 0 {
+			return &numberError{len(text), "exponent digits", "missing exponent digits"}
+		}
+		e, err := strconv.Atoi(strings.ReplaceAll(strings.TrimLeft(text[exp+1:], "+-"), "_", ""))
+		if err != nil || e > maxLiteralExponent {
+			return &numberError{exp, "smaller exponent", fmt.Sprintf("exponent is out of range (at most %d)", maxLiteralExponent)}
+		}
+	}
+	return nil
+}
+
+// numberValue переводит точное значение литерала в float64
+func numberValue(r *big.Rat) (float64, bool) {
+	f, _ := r.Float64()
+	return f, !math.IsInf(f, 0)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digitValue возвращает значение шестнадцатеричной цифры, 99 — не цифра
+func digitValue(c byte) int {
+	switch {
+	case isDigit(c):
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return 99
+}
@@ -0,0 +1,90 @@
+package calculation
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Number — float64, который переживает JSON и БД и тогда, когда он NaN или
+// бесконечность: такие значения записываются строками "NaN", "Infinity",
+// "-Infinity", а конечные — обычными числами.
+type Number float64
+
+func (n Number) MarshalJSON() ([]byte, error) {
+	v := float64(n)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return json.Marshal(Format(v, FormatOptions{}))
+	}
+	return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+}
+
+func (n *Number) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return n.parse(s)
+	}
+	v, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return err
+	}
+	*n = Number(v)
+	return nil
+}
+
+func (n *Number) parse(s string) error {
+	switch s {
+	case TextNaN:
+		*n = Number(math.NaN())
+	case TextInf, "+Inf", "Inf":
+		*n = Number(math.Inf(1))
+	case TextNegInf, "-Inf":
+		*n = Number(math.Inf(-1))
+	default:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		*n = Number(v)
+	}
+	return nil
+}
+
+// Value сохраняет NaN и бесконечности в БД текстом: SQLite превращает NaN в NULL
+func (n Number) Value() (driver.Value, error) {
+	v := float64(n)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return Format(v, FormatOptions{}), nil
+	}
+	return v, nil
+}
+
+func (n *Number) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case float64:
+		*n = Number(v)
+	case int64:
+		*n = Number(v)
+	case string:
+		return n.parse(v)
+	case []byte:
+		return n.parse(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into Number", src)
+	}
+	return nil
+}
+
+// Floats переводит []Number в []float64
+func Floats(ns []Number) []float64 {
+	res := make([]float64, len(ns))
+	for i, n := range ns {
+		res[i] = float64(n)
+	}
+	return res
+}
@@ -0,0 +1,268 @@
+package calculation
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Оптимизатор упрощает дерево до раздачи задач агентам. Применяются только
+// тождества, точные в IEEE 754 и не теряющие ошибок вычисления:
+//
+//	x*1, 1*x, x/1, x^1 -> x
+//	x-0 -> x (но не x+0: -0+0 = +0)
+//	x^0 -> 1, если x вычисляется без ошибки
+//	0*x, x*0 -> ±0, если x конечен, его знак известен и он вычисляется без ошибки
+//	x-x -> 0, если x конечен и вычисляется без ошибки
+//	-(число) -> число с обратным знаком
+//
+// Поддерево, которое может завершиться ошибкой (1/0, sqrt(-1)), никогда не
+// выбрасывается: 0*(1/0) по-прежнему даёт division by zero. В точном режиме
+// так может завершиться и любая степень (слишком большой результат), поэтому
+// там 0*(1^10000000) не упрощается.
+
+// Rewrite описывает одно применённое упрощение
+type Rewrite struct {
+	Rule   string `json:"rule"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Optimize упрощает дерево на месте и возвращает новый корень и список
+// применённых упрощений в порядке применения (снизу вверх)
+func Optimize(n *ASTNode) (*ASTNode, []Rewrite) {
+	var rewrites []Rewrite
+	return optimize(n, &rewrites, false), rewrites
+}
+
+// OptimizeExact — Optimize для выражения, которое будет считаться в точном режиме
+func OptimizeExact(n *ASTNode) (*ASTNode, []Rewrite) {
+	var rewrites []Rewrite
+	return optimize(n, &rewrites, true), rewrites
+}
+
+func optimize(n *ASTNode, rewrites *[]Rewrite, exact bool) *ASTNode {
+	if n == nil || n.IsLeaf {
+		return n
+	}
+	if n.Func != "" {
+		for i, arg := range n.Args {
+			n.Args[i] = optimize(arg, rewrites, exact)
+		}
+		return n
+	}
+	n.Left = optimize(n.Left, rewrites, exact)
+	if n.Right != nil {
+		n.Right = optimize(n.Right, rewrites, exact)
+	}
+	rule, res := simplify(n, exact)
+	if res == nil {
+		return n
+	}
+	*rewrites = append(*rewrites, Rewrite{Rule: rule, Before: n.String(), After: res.String()})
+	return res
+}
+
+// simplify применяет к узлу первое подходящее тождество
+func simplify(n *ASTNode, exact bool) (string, *ASTNode) {
+	l, r := n.Left, n.Right
+	switch n.Operator {
+	case "neg":
+		if l.IsLeaf {
+			res := leaf(-l.Value)
+			if l.Exact != "" {
+				res.Exact = NegExact(l.Exact)
+			}
+			if l.Literal != "" {
+				res.Literal = "-" + l.Literal
+			}
+			return "-c", res
+		}
+	case "*":
+		switch {
+		case isConst(r, 1):
+			return "x*1", l
+		case isConst(l, 1):
+			return "1*x", r
+		case isConst(l, 0):
+			if z := zeroProduct(l, r, exact); z != nil {
+				return "0*x", z
+			}
+		case isConst(r, 0):
+			if z := zeroProduct(r, l, exact); z != nil {
+				return "x*0", z
+			}
+		}
+	case "/":
+		if isConst(r, 1) {
+			return "x/1", l
+		}
+	case "^":
+		switch {
+		case isConst(r, 1):
+			return "x^1", l
+		case isConst(r, 0) && analyze(l, exact).safe:
+			// math.Pow(x, ±0) = 1 для любого x, даже NaN
+			return "x^0", leaf(1)
+		}
+	case "-":
+		switch {
+		case isConst(r, 0) && !math.Signbit(r.Value):
+			return "x-0", l
+		case l.ExactString() == r.ExactString():
+			if f := analyze(l, exact); f.safe && f.finite() {
+				return "x-x", leaf(0)
+			}
+		}
+	}
+	return "", nil
+}
+
+// zeroProduct возвращает ±0 — значение zero*x, если его можно знать заранее
+func zeroProduct(zero, x *ASTNode, exact bool) *ASTNode {
+	f := analyze(x, exact)
+	if !f.safe || !f.finite() || f.sign == 0 {
+		return nil
+	}
+	// знак произведения — исключающее ИЛИ знаков множителей
+	return leaf(math.Copysign(0, float64(signOf(zero.Value)*f.sign)))
+}
+
+func leaf(v float64) *ASTNode {
+	return &ASTNode{IsLeaf: true, Value: v, Exact: strconv.FormatFloat(v, 'g', -1, 64)}
+}
+
+// isConst сравнивает лист с числом и по float64, и по точной записи:
+// 1.0000000000000000001 — не единица в точном режиме
+func isConst(n *ASTNode, v float64) bool {
+	if n == nil || !n.IsLeaf || n.Value != v {
+		return false
+	}
+	r := leafRat(n)
+	return r == nil || r.Cmp(new(big.Rat).SetFloat64(v)) == 0
+}
+
+func leafRat(n *ASTNode) *big.Rat {
+	if n.Exact == "" {
+		return nil
+	}
+	r, _ := new(big.Rat).SetString(n.Exact)
+	return r
+}
+
+func signOf(v float64) int {
+	if math.Signbit(v) {
+		return -1
+	}
+	return 1
+}
+
+// facts — что известно о значении поддерева без его вычисления
+type facts struct {
+	bound float64 // оценка |x| сверху, +Inf — неизвестна
+	sign  int     // знаковый бит результата: 1, -1 или 0 — неизвестен
+	safe  bool    // вычисление не может завершиться ошибкой
+}
+
+// finite сообщает, что значение заведомо конечно (и потому не NaN).
+// Округление монотонно, поэтому оценка, посчитанная теми же операциями над
+// модулями, не меньше настоящего модуля результата.
+func (f facts) finite() bool {
+	return !math.IsInf(f.bound, 1) && !math.IsNaN(f.bound)
+}
+
+var unknown = facts{bound: math.Inf(1)}
+
+// analyze оценивает поддерево; exact — оно будет считаться в точном режиме
+func analyze(n *ASTNode, exact bool) facts {
+	switch {
+	case n == nil:
+		return unknown
+	case n.IsLeaf:
+		return facts{bound: math.Abs(n.Value), sign: signOf(n.Value), safe: true}
+	case n.Ref != "":
+		// результат другого выражения может быть любым
+		return unknown
+	case n.Func != "":
+		return analyzeFunc(n, exact)
+	}
+	a := analyze(n.Left, exact)
+	if n.Operator == "neg" {
+		a.sign = -a.sign
+		return a
+	}
+	b := analyze(n.Right, exact)
+	res := facts{bound: math.Inf(1), safe: a.safe && b.safe}
+	switch n.Operator {
+	case "+":
+		res.bound = a.bound + b.bound
+		if a.sign == b.sign {
+			res.sign = a.sign
+		}
+	case "-":
+		res.bound = a.bound + b.bound
+		if a.sign == -b.sign {
+			res.sign = a.sign
+		}
+	case "*":
+		res.bound = a.bound * b.bound
+		res.sign = a.sign * b.sign
+	case "/":
+		res.sign = a.sign * b.sign
+		// без ошибки делится только на ненулевое число
+		if n.Right.IsLeaf && n.Right.Value != 0 {
+			res.bound = a.bound / math.Abs(n.Right.Value)
+		} else {
+			res.safe = false
+		}
+	case "^":
+		// без ошибки возводится только в целую неотрицательную степень, а в
+		// точном режиме и она может превысить предел размера результата
+		e := n.Right
+		if exact || !e.IsLeaf || e.Value < 0 || e.Value != math.Trunc(e.Value) || (leafRat(e) != nil && !leafRat(e).IsInt()) {
+			res.safe = false
+			break
+		}
+		res.bound = math.Pow(a.bound, e.Value)
+		if math.Mod(e.Value, 2) == 0 {
+			res.sign = 1
+		} else {
+			res.sign = a.sign
+		}
+	default:
+		res.safe = false
+	}
+	return res
+}
+
+func analyzeFunc(n *ASTNode, exact bool) facts {
+	args := make([]facts, len(n.Args))
+	safe := true
+	for i, arg := range n.Args {
+		args[i] = analyze(arg, exact)
+		safe = safe && args[i].safe
+	}
+	switch n.Func {
+	case "sin", "cos":
+		// sin(±Inf) = NaN
+		if !args[0].finite() {
+			return facts{bound: math.Inf(1), safe: safe}
+		}
+		return facts{bound: 1, safe: safe}
+	case "abs":
+		return facts{bound: args[0].bound, sign: 1, safe: safe}
+	case "exp":
+		return facts{bound: math.Exp(args[0].bound), sign: 1, safe: safe}
+	case "min", "max":
+		res := facts{bound: 0, sign: args[0].sign, safe: safe}
+		for _, a := range args {
+			res.bound = math.Max(res.bound, a.bound)
+			if a.sign != res.sign {
+				res.sign = 0
+			}
+		}
+		return res
+	}
+	// sqrt и log могут выйти из области определения
+	return unknown
+}
@@ -0,0 +1,89 @@
+package calculation
+
+import "fmt"
+
+// Ссылки на другие выражения: ans — последнее выражение пользователя,
+// $id — выражение с таким ID. Значение ссылки известно, только когда то
+// выражение вычислено, поэтому парсер оставляет на её месте узел с Ref,
+// а число подставляет тот, кто знает результаты (см. BindReference).
+// Пока ссылка не заменена числом, узел не лист, и Eval возвращает ErrReference.
+
+// RefLast — ссылка на последнее выражение пользователя
+const RefLast = "ans"
+
+// parseRef разбирает ссылку $id
+func (p *parser) parseRef() (*ASTNode, error) {
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.input) && isNameChar(p.input[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, p.errorf("expression id", "missing expression id after $")
+	}
+	return &ASTNode{Ref: p.input[start:p.pos]}, nil
+}
+
+// References возвращает ссылки дерева, ещё не заменённые числами, без повторов
+func References(n *ASTNode) []string {
+	var refs []string
+	seen := make(map[string]bool)
+	var walk func(n *ASTNode)
+	walk = func(n *ASTNode) {
+		if n == nil {
+			return
+		}
+		if n.Ref != "" && !n.IsLeaf && !seen[n.Ref] {
+			seen[n.Ref] = true
+			refs = append(refs, n.Ref)
+		}
+		for _, c := range n.Children() {
+			walk(c)
+		}
+	}
+	walk(n)
+	return refs
+}
+
+// ReplaceReference направляет ссылки ref на выражение id, например ans — на
+// конкретное выражение
+func ReplaceReference(n *ASTNode, ref, id string) {
+	if n == nil {
+		return
+	}
+	if n.Ref == ref && !n.IsLeaf {
+		n.Ref = id
+	}
+	for _, c := range n.Children() {
+		ReplaceReference(c, ref, id)
+	}
+}
+
+// BindReference подставляет значение во все ссылки на выражение ref.
+// Узлы становятся листьями, Ref остаётся для наглядности.
+func BindReference(n *ASTNode, ref string, v Variable) {
+	if n == nil {
+		return
+	}
+	if n.Ref == ref && !n.IsLeaf {
+		n.IsLeaf = true
+		n.Value = float64(v.Value)
+		n.Exact = v.Exact
+		return
+	}
+	for _, c := range n.Children() {
+		BindReference(c, ref, v)
+	}
+}
+
+func refError(n *ASTNode) error {
+	return fmt.Errorf("%w: %s", ErrReference, n.refText())
+}
+
+// refText — ссылка так, как она записана в выражении
+func (n *ASTNode) refText() string {
+	if n.Ref == RefLast {
+		return RefLast
+	}
+	return "$" + n.Ref
+}
@@ -0,0 +1,142 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Имена в выражении — встроенные константы и переменные пользователя.
+// Значение подставляется при разборе, так что в дереве остаётся обычный лист
+// с полем Var, а вычисление и кэш работают с числом.
+
+// maxNameLength ограничивает длину имени переменной
+const maxNameLength = 64
+
+// Variable — значение имени. Exact — точное значение для точного режима;
+// у иррациональных констант оно пустое, и в точном режиме их использовать нельзя.
+type Variable struct {
+	Value Number `json:"value"`
+	Exact string `json:"exact,omitempty"`
+}
+
+// Constants — встроенные константы
+var Constants = map[string]Variable{
+	"pi": {Value: math.Pi},
+	"e":  {Value: math.E},
+}
+
+// ParseASTWith разбирает выражение, подставляя вместо имён значения из vars
+// и Constants. Ошибки разбора имеют тип *SyntaxError.
+func ParseASTWith(expr string, vars map[string]Variable) (*ASTNode, error) {
+	p := &parser{input: expr, vars: vars}
+	ast, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != 0 {
+		return nil, p.errorf("operator or end of input", "unexpected symbol")
+	}
+	return ast, nil
+}
+
+// CheckName проверяет, что имя годится для переменной: буква, затем буквы,
+// цифры и _, и имя не занято функцией или константой
+func CheckName(name string) error {
+	if name == "" || len(name) > maxNameLength {
+		return fmt.Errorf("name must be 1 to %d characters long", maxNameLength)
+	}
+	if !isLetter(name[0]) {
+		return fmt.Errorf("name %q must start with a letter", name)
+	}
+	for i := 1; i < len(name); i++ {
+		if !isNameChar(name[i]) {
+			return fmt.Errorf("name %q may contain only letters, digits and _", name)
+		}
+	}
+	if IsFunc(name) {
+		return fmt.Errorf("name %q is a function", name)
+	}
+	if _, ok := Constants[name]; ok {
+		return fmt.Errorf("name %q is a built-in constant", name)
+	}
+	if name == RefLast {
+		return fmt.Errorf("name %q is reserved for the last result", name)
+	}
+	return nil
+}
+
+// ParseVariable разбирает значение переменной: число в любой записи, которую
+// понимает выражение, со знаком, или дробь "1/3"
+func ParseVariable(text string) (Variable, error) {
+	text = strings.TrimSpace(text)
+	num, den, isFrac := strings.Cut(text, "/")
+	r, err := parseSigned(strings.TrimSpace(num))
+	if err != nil {
+		return Variable{}, err
+	}
+	if isFrac {
+		d, err := parseSigned(strings.TrimSpace(den))
+		if err != nil {
+			return Variable{}, err
+		}
+		if d.Sign() == 0 {
+			return Variable{}, ErrDivisionByZero
+		}
+		r.Quo(r, d)
+	}
+	v, ok := numberValue(r)
+	if !ok {
+		return Variable{}, fmt.Errorf("number %q is out of range", text)
+	}
+	return Variable{Value: Number(v), Exact: r.RatString()}, nil
+}
+
+func parseSigned(text string) (*big.Rat, error) {
+	neg := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "-"), "+")
+	if text == "" || (!isDigit(text[0]) && text[0] != '.') || scanNumber(text) != len(text) {
+		return nil, fmt.Errorf("invalid number %q", text)
+	}
+	r, nerr := parseNumber(text)
+	if nerr != nil {
+		return nil, fmt.Errorf("invalid number %q: %s", text, nerr.message)
+	}
+	if neg {
+		r.Neg(r)
+	}
+	return r, nil
+}
+
+// UsedVariables возвращает имена, подставленные в дерево, с их значениями
+func UsedVariables(n *ASTNode) map[string]Variable {
+	used := make(map[string]Variable)
+	var walk func(n *ASTNode)
+	walk = func(n *ASTNode) {
+		if n == nil {
+			return
+		}
+		if n.IsLeaf && n.Var != "" {
+			used[n.Var] = Variable{Value: Number(n.Value), Exact: n.Exact}
+		}
+		for _, c := range n.Children() {
+			walk(c)
+		}
+	}
+	walk(n)
+	return used
+}
+
+// lookup ищет значение имени: сначала переменные, потом константы
+func (p *parser) lookup(name string) (Variable, bool) {
+	if v, ok := p.vars[name]; ok {
+		return v, true
+	}
+	v, ok := Constants[name]
+	return v, ok
+}
+
+func isNameChar(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == '_'
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zakharkaverin1/final_calca/internal/application"
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+func TestParseAST_PowerIsRightAssociative(t *testing.T) {
+	ast, err := application.ParseAST("2^3^2")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if ast.Operator != "^" || !ast.Left.IsLeaf || ast.Left.Value != 2 {
+		t.Fatalf("Ожидалось 2^(3^2), получено %+v", ast)
+	}
+	if ast.Right.Operator != "^" || ast.Right.Left.Value != 3 || ast.Right.Right.Value != 2 {
+		t.Errorf("Правый операнд должен быть 3^2, получено %+v", ast.Right)
+	}
+}
+
+func TestParseAST_PowerBindsTighterThanMultiplication(t *testing.T) {
+	ast, err := application.ParseAST("3*2^2")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if ast.Operator != "*" || ast.Right.Operator != "^" {
+		t.Errorf("Ожидалось 3*(2^2), получено %+v", ast)
+	}
+}
+
+func TestParseAST_FunctionCall(t *testing.T) {
+	ast, err := application.ParseAST("max(1, 2*3, sqrt(4))")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if ast.Func != "max" || len(ast.Args) != 3 {
+		t.Fatalf("Ожидался вызов max с тремя аргументами, получено %+v", ast)
+	}
+	if ast.Args[1].Operator != "*" || ast.Args[2].Func != "sqrt" {
+		t.Errorf("Аргументы разобраны неверно: %+v", ast.Args)
+	}
+}
+
+func TestParseAST_FunctionErrors(t *testing.T) {
+	for _, expr := range []string{"foo(1)", "sqrt(1,2)", "min()", "sqrt 4"} {
+		if _, err := application.ParseAST(expr); err == nil {
+			t.Errorf("Ожидалась ошибка для %q", expr)
+		}
+	}
+}
+
+func TestParseAST_UnaryMinus(t *testing.T) {
+	ast, err := application.ParseAST("-2^2")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if ast.Operator != "neg" || ast.Left.Operator != "^" {
+		t.Errorf("Ожидалось -(2^2), получено %+v", ast)
+	}
+	ast, err = application.ParseAST("2*-(4)")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if ast.Operator != "*" || ast.Right.Operator != "neg" {
+		t.Errorf("Ожидалось 2*(-4), получено %+v", ast)
+	}
+	ast, err = application.ParseAST("+3")
+	if err != nil || !ast.IsLeaf || ast.Value != 3 {
+		t.Errorf("Унарный плюс не должен создавать узел, получено %+v (%v)", ast, err)
+	}
+}
+
+func TestParseAST_StackedSigns(t *testing.T) {
+	cases := map[string]float64{"3+-2": 1, "4 - - 1": 5, "--2": 2, "-+1": -1, "3++2": 5, "2*--3": 6, "-(-2)": 2}
+	for expr, want := range cases {
+		ast, err := application.ParseAST(expr)
+		if err != nil {
+			t.Errorf("%q: неожиданная ошибка: %v", expr, err)
+			continue
+		}
+		if res, err := calculation.Eval(ast); err != nil || res != want {
+			t.Errorf("%q: получено %v, %v, ожидалось %v", expr, res, err, want)
+		}
+	}
+}
+
+func TestParseAST_AgreesWithValid(t *testing.T) {
+	exprs := []string{
+		"-(2+3)", "2*-(4)", "3*-2", "2^-1", "max(1,-2)", "-5",
+		"3++2", "3+-2", "4 - - 1", "--2", "-+1", "*2", "2*", "(-)", "1,2", "2-",
+	}
+	for _, expr := range exprs {
+		_, err := application.ParseAST(expr)
+		if valid := application.Valid(expr); valid != (err == nil) {
+			t.Errorf("%q: Valid=%v, ошибка разбора: %v", expr, valid, err)
+		}
+	}
+}
+
+func TestParseAST_SyntaxErrorPosition(t *testing.T) {
+	_, err := application.ParseAST("3 + * 2")
+	var syntaxErr *application.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("Ожидалась SyntaxError, получено %v", err)
+	}
+	if syntaxErr.Position != 4 || syntaxErr.Expected != "number" || syntaxErr.Found != `'*'` {
+		t.Errorf("Неверное описание ошибки: %+v", syntaxErr)
+	}
+	if want := "3 + * 2\n    ^"; syntaxErr.Snippet() != want {
+		t.Errorf("Ожидался фрагмент %q, получен %q", want, syntaxErr.Snippet())
+	}
+}
+
+func TestParseAST_MissingParenthesis(t *testing.T) {
+	_, err := application.ParseAST("(3+2")
+	var syntaxErr *application.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("Ожидалась SyntaxError, получено %v", err)
+	}
+	if syntaxErr.Position != 4 || syntaxErr.Expected != ")" || syntaxErr.Found != "end of input" {
+		t.Errorf("Неверное описание ошибки: %+v", syntaxErr)
+	}
+}
+
+func TestASTNode_StringCanonical(t *testing.T) {
+	same := []string{"(1+2)*3", "((1 + 2)) * 3.0", "(1+2)*(3)"}
+	var want string
+	for i, expr := range same {
+		ast, err := application.ParseAST(expr)
+		if err != nil {
+			t.Fatalf("ParseAST(%q): %v", expr, err)
+		}
+		got := ast.String()
+		if i == 0 {
+			want = got
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: каноническая запись %q, ожидалась %q", expr, got, want)
+		}
+	}
+
+	a, _ := application.ParseAST("1-2-3")
+	b, _ := application.ParseAST("1-(2-3)")
+	if a.String() == b.String() {
+		t.Errorf("Разные выражения дали одну запись %q", a.String())
+	}
+}
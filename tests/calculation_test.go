@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+func TestCompute_DivisionByZero(t *testing.T) {
+	_, err := calculation.Compute("/", 1, 0)
+	if !errors.Is(err, calculation.ErrDivisionByZero) {
+		t.Fatalf("Ожидалась ErrDivisionByZero, получено %v", err)
+	}
+	if code := calculation.ErrorCode(err); code != calculation.CodeDivisionByZero {
+		t.Errorf("Ожидался код %s, получен %s", calculation.CodeDivisionByZero, code)
+	}
+}
+
+func TestCompute_InvalidOperator(t *testing.T) {
+	_, err := calculation.Compute("%", 1, 2)
+	if !errors.Is(err, calculation.ErrInvalidOperator) {
+		t.Fatalf("Ожидалась ErrInvalidOperator, получено %v", err)
+	}
+	if code := calculation.ErrorCode(err); code != calculation.CodeInvalidOperator {
+		t.Errorf("Ожидался код %s, получен %s", calculation.CodeInvalidOperator, code)
+	}
+}
+
+func TestCompute_Power(t *testing.T) {
+	res, err := calculation.Compute("^", 2, 10)
+	if err != nil || res != 1024 {
+		t.Errorf("Ожидалось 1024, получено %v (%v)", res, err)
+	}
+	res, err = calculation.Compute("^", -8, 3)
+	if err != nil || res != -512 {
+		t.Errorf("Ожидалось -512, получено %v (%v)", res, err)
+	}
+}
+
+func TestCompute_PowerDomainErrors(t *testing.T) {
+	_, err := calculation.Compute("^", -8, 0.5)
+	if calculation.ErrorCode(err) != calculation.CodeDomain {
+		t.Errorf("Ожидалась ошибка области определения, получено %v", err)
+	}
+	_, err = calculation.Compute("^", 0, -1)
+	if calculation.ErrorCode(err) != calculation.CodeDivisionByZero {
+		t.Errorf("Ожидалось деление на ноль, получено %v", err)
+	}
+}
+
+func TestComputeFunc(t *testing.T) {
+	cases := []struct {
+		name string
+		args []float64
+		want float64
+	}{
+		{"sqrt", []float64{16}, 4},
+		{"abs", []float64{-3}, 3},
+		{"min", []float64{3, -1, 2}, -1},
+		{"max", []float64{3, 4, 5}, 5},
+		{"exp", []float64{0}, 1},
+	}
+	for _, c := range cases {
+		res, err := calculation.ComputeFunc(c.name, c.args)
+		if err != nil || res != c.want {
+			t.Errorf("%s(%v): ожидалось %v, получено %v (%v)", c.name, c.args, c.want, res, err)
+		}
+	}
+}
+
+func TestComputeFunc_Errors(t *testing.T) {
+	if _, err := calculation.ComputeFunc("sqrt", []float64{-1}); calculation.ErrorCode(err) != calculation.CodeDomain {
+		t.Errorf("Ожидалась ошибка области определения, получено %v", err)
+	}
+	if _, err := calculation.ComputeFunc("log", []float64{0}); calculation.ErrorCode(err) != calculation.CodeDomain {
+		t.Errorf("Ожидалась ошибка области определения, получено %v", err)
+	}
+	if _, err := calculation.ComputeFunc("sin", []float64{1, 2}); calculation.ErrorCode(err) != calculation.CodeArgumentCount {
+		t.Errorf("Ожидалась ошибка числа аргументов, получено %v", err)
+	}
+}
+
+func TestCalc(t *testing.T) {
+	cases := map[string]float64{
+		"2+2*2":              6,
+		"(1+2)*(3+4)-5":      16,
+		"2^3^2":              512,
+		"-2^2":               -4,
+		"2*-(4)":             -8,
+		"max(1, 2*3, 4)":     6,
+		"sqrt(16) + abs(-3)": 7,
+		"0.1+0.2":            0.30000000000000004,
+	}
+	for expr, want := range cases {
+		res, err := calculation.Calc(expr)
+		if err != nil || res != want {
+			t.Errorf("%q: ожидалось %v, получено %v (%v)", expr, want, res, err)
+		}
+	}
+}
+
+func TestCalc_Errors(t *testing.T) {
+	if _, err := calculation.Calc("1/(2-2)"); !errors.Is(err, calculation.ErrDivisionByZero) {
+		t.Errorf("Ожидалась ErrDivisionByZero, получено %v", err)
+	}
+	var syntaxErr *calculation.SyntaxError
+	if _, err := calculation.Calc("1+"); !errors.As(err, &syntaxErr) {
+		t.Errorf("Ожидалась SyntaxError, получено %v", err)
+	}
+}
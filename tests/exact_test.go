@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+func TestComputeExact(t *testing.T) {
+	cases := []struct {
+		op   string
+		args []string
+		want string
+	}{
+		{"+", []string{"0.1", "0.2"}, "3/10"},
+		{"/", []string{"1", "3"}, "1/3"},
+		{"-", []string{"1/3", "1/3"}, "0"},
+		{"^", []string{"2/3", "-2"}, "9/4"},
+		{"^", []string{"10", "30"}, "1000000000000000000000000000000"},
+		{"max", []string{"1/3", "0.3", "-5"}, "1/3"},
+		{"abs", []string{"-7/2"}, "7/2"},
+	}
+	for _, c := range cases {
+		got, err := calculation.ComputeExact(c.op, c.args)
+		if err != nil {
+			t.Errorf("%s %v: неожиданная ошибка: %v", c.op, c.args, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s %v: получено %s, ожидалось %s", c.op, c.args, got, c.want)
+		}
+	}
+}
+
+func TestComputeExact_Errors(t *testing.T) {
+	if _, err := calculation.ComputeExact("/", []string{"1", "0"}); !errors.Is(err, calculation.ErrDivisionByZero) {
+		t.Errorf("Ожидалась ErrDivisionByZero, получено %v", err)
+	}
+	if _, err := calculation.ComputeExact("^", []string{"2", "1/2"}); !errors.Is(err, calculation.ErrInexact) {
+		t.Errorf("Ожидалась ErrInexact для дробной степени, получено %v", err)
+	}
+	if _, err := calculation.ComputeExact("sqrt", []string{"4"}); calculation.ErrorCode(err) != calculation.CodeInexact {
+		t.Errorf("Ожидался код %s для sqrt, получено %v", calculation.CodeInexact, err)
+	}
+	if _, err := calculation.ComputeExact("^", []string{"10", "1000000000"}); !errors.Is(err, calculation.ErrDomain) {
+		t.Errorf("Ожидалась ErrDomain для слишком большой степени, получено %v", err)
+	}
+}
+
+func TestFormatExact(t *testing.T) {
+	if got, _ := calculation.FormatExact("1/3", -1); got != "1/3" {
+		t.Errorf("Дробью: получено %s", got)
+	}
+	if got, _ := calculation.FormatExact("2/3", 5); got != "0.66667" {
+		t.Errorf("5 знаков: получено %s", got)
+	}
+	if got, _ := calculation.FormatExact("0.30", 0); got != "0" {
+		t.Errorf("0 знаков: получено %s", got)
+	}
+}
+
+func TestASTNode_ExactString(t *testing.T) {
+	a, _ := calculation.ParseAST("0.1 + 3.0")
+	b, _ := calculation.ParseAST("0.10000000000000000001+3")
+	if a.String() != b.String() {
+		t.Errorf("В обычном режиме записи должны совпадать: %s и %s", a, b)
+	}
+	if a.ExactString() != "(1/10+3)" {
+		t.Errorf("Получено %s, ожидалось (1/10+3)", a.ExactString())
+	}
+	if a.ExactString() == b.ExactString() {
+		t.Errorf("В точном режиме записи должны различаться: %s", a.ExactString())
+	}
+	// 1.0000000000000000001 — не единица, умножение на него не выбрасывается
+	c, _ := calculation.ParseAST("3*1.0000000000000000001")
+	if _, rewrites := calculation.Optimize(c); len(rewrites) != 0 {
+		t.Errorf("Неожиданные упрощения %+v", rewrites)
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+func intp(v int) *int { return &v }
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		v    float64
+		opts calculation.FormatOptions
+		want string
+	}{
+		{0.30000000000000004, calculation.FormatOptions{}, "0.30000000000000004"},
+		{1e21, calculation.FormatOptions{}, "1e+21"},
+		{2.5, calculation.FormatOptions{Precision: intp(0)}, "2"},
+		{2.5, calculation.FormatOptions{Precision: intp(0), Rounding: calculation.RoundHalfUp}, "3"},
+		{-2.5, calculation.FormatOptions{Precision: intp(0), Rounding: calculation.RoundFloor}, "-3"},
+		{-2.5, calculation.FormatOptions{Precision: intp(0), Rounding: calculation.RoundDown}, "-2"},
+		// 2.675 в float64 чуть меньше 2.675
+		{2.675, calculation.FormatOptions{Precision: intp(2), Rounding: calculation.RoundHalfUp}, "2.67"},
+		{1.0 / 3, calculation.FormatOptions{Precision: intp(4)}, "0.3333"},
+		{123456, calculation.FormatOptions{Significant: intp(2)}, "120000"},
+		{9.996, calculation.FormatOptions{Significant: intp(3)}, "10.0"},
+		{0.000123456, calculation.FormatOptions{Significant: intp(3)}, "0.000123"},
+		{123456, calculation.FormatOptions{Significant: intp(3), Notation: calculation.NotationScientific}, "1.23e+5"},
+		{0.00012, calculation.FormatOptions{Precision: intp(1), Notation: calculation.NotationScientific}, "1.2e-4"},
+		{0, calculation.FormatOptions{Precision: intp(2)}, "0.00"},
+		{math.NaN(), calculation.FormatOptions{Precision: intp(2)}, "NaN"},
+		{math.Inf(-1), calculation.FormatOptions{}, "-Infinity"},
+	}
+	for _, c := range cases {
+		if got := calculation.Format(c.v, c.opts); got != c.want {
+			t.Errorf("Format(%v, %+v): получено %s, ожидалось %s", c.v, c.opts, got, c.want)
+		}
+	}
+}
+
+func TestFormatOptions_Validate(t *testing.T) {
+	bad := []calculation.FormatOptions{
+		{Precision: intp(2), Significant: intp(3)},
+		{Precision: intp(-1)},
+		{Significant: intp(0)},
+		{Notation: "roman"},
+		{Rounding: "random"},
+	}
+	for _, opts := range bad {
+		if opts.Validate() == nil {
+			t.Errorf("Ожидалась ошибка для %+v", opts)
+		}
+	}
+}
+
+func TestNumber_JSON(t *testing.T) {
+	in := []calculation.Number{1.5, calculation.Number(math.Inf(1)), calculation.Number(math.NaN())}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if string(data) != `[1.5,"Infinity","NaN"]` {
+		t.Errorf("Получено %s", data)
+	}
+	var out []calculation.Number
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if out[0] != 1.5 || !math.IsInf(float64(out[1]), 1) || !math.IsNaN(float64(out[2])) {
+		t.Errorf("Получено %v", out)
+	}
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zakharkaverin1/final_calca/internal/application"
+)
+
+func TestParseAST_NumberLiterals(t *testing.T) {
+	cases := []struct {
+		expr  string
+		value float64
+		exact string
+	}{
+		{"1e-9", 1e-9, "1/1000000000"},
+		{"6.02E23", 6.02e23, "602000000000000000000000"},
+		{"2.5e+3", 2500, "2500"},
+		{"0xFF", 255, "255"},
+		{"0b1010", 10, "10"},
+		{"0o17", 15, "15"},
+		{"1_000_000", 1e6, "1000000"},
+		{".5", 0.5, "1/2"},
+	}
+	for _, c := range cases {
+		ast, err := application.ParseAST(c.expr)
+		if err != nil {
+			t.Errorf("ParseAST(%q): %v", c.expr, err)
+			continue
+		}
+		if !ast.IsLeaf || ast.Value != c.value || ast.Exact != c.exact || ast.Literal != c.expr {
+			t.Errorf("%q: получено %+v", c.expr, ast)
+		}
+	}
+
+	// знак после e в шестнадцатеричном числе — сложение
+	ast, err := application.ParseAST("0x1e+1")
+	if err != nil || ast.Operator != "+" || ast.Left.Value != 30 {
+		t.Errorf("0x1e+1: получено %+v, %v", ast, err)
+	}
+}
+
+func TestParseAST_MalformedNumbers(t *testing.T) {
+	cases := []struct {
+		expr     string
+		position int
+	}{
+		{"1.2.3", 3},
+		{"2+0b102", 6},
+		{"1e", 2},
+		{"1__0", 1},
+		{"1_", 1},
+		{"0x", 2},
+		{"0x1.8", 3},
+		{"12abc", 2},
+		{"1e99999", 1},
+		{"1e400", 0},
+	}
+	for _, c := range cases {
+		_, err := application.ParseAST(c.expr)
+		var syntaxErr *application.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: ожидалась SyntaxError, получено %v", c.expr, err)
+			continue
+		}
+		if syntaxErr.Position != c.position {
+			t.Errorf("%q: позиция %d, ожидалась %d (%v)", c.expr, syntaxErr.Position, c.position, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+func TestOptimize_Identities(t *testing.T) {
+	cases := []struct {
+		expr, want string
+		rules      []string
+	}{
+		{"(2+3)*1", "(2+3)", []string{"x*1"}},
+		{"1*(2+3)/1", "(2+3)", []string{"1*x", "x/1"}},
+		{"(2+3)^1-0", "(2+3)", []string{"x^1", "x-0"}},
+		{"(2+3)-(2+3)", "0", []string{"x-x"}},
+		{"0*(2*(-7))", "-0", []string{"-c", "0*x"}},
+		{"(2*sin(1))^0", "1", []string{"x^0"}},
+		{"2*(-3)", "(2*-3)", []string{"-c"}},
+		// x+0 не упрощается: -0+0 = +0
+		{"(2+3)+0", "((2+3)+0)", nil},
+	}
+	for _, c := range cases {
+		ast, err := calculation.ParseAST(c.expr)
+		if err != nil {
+			t.Fatalf("%s: неожиданная ошибка: %v", c.expr, err)
+		}
+		res, rewrites := calculation.Optimize(ast)
+		if got := res.String(); got != c.want {
+			t.Errorf("%s: получено %s, ожидалось %s", c.expr, got, c.want)
+		}
+		if len(rewrites) != len(c.rules) {
+			t.Errorf("%s: упрощения %+v, ожидались %v", c.expr, rewrites, c.rules)
+			continue
+		}
+		for i, rw := range rewrites {
+			if rw.Rule != c.rules[i] {
+				t.Errorf("%s: упрощение %d — %s, ожидалось %s", c.expr, i, rw.Rule, c.rules[i])
+			}
+		}
+	}
+}
+
+func TestOptimize_KeepsErrorsAndSpecialValues(t *testing.T) {
+	for _, expr := range []string{
+		"0*(1/0)",           // ошибка деления на ноль должна остаться
+		"sqrt(2-5)^0",       // как и ошибка области определения
+		"0*exp(1000)",       // exp(1000) = +Inf, 0*Inf = NaN
+		"0*(2-sin(1))",      // знак неизвестен: результат +0 или -0
+		"exp(800)-exp(800)", // Inf-Inf = NaN
+	} {
+		ast, err := calculation.ParseAST(expr)
+		if err != nil {
+			t.Fatalf("%s: неожиданная ошибка: %v", expr, err)
+		}
+		before := ast.String()
+		res, rewrites := calculation.Optimize(ast)
+		if len(rewrites) != 0 || res.String() != before {
+			t.Errorf("%s: упрощать нельзя, получено %s (%+v)", expr, res, rewrites)
+		}
+	}
+}
+
+func TestOptimize_SameValue(t *testing.T) {
+	for _, expr := range []string{"0*(2*(-7))", "(-0)*5", "(3*4-2)^0*1", "max(1,2)-max(1,2)"} {
+		want, err := calculation.Calc(expr)
+		if err != nil {
+			t.Fatalf("%s: неожиданная ошибка: %v", expr, err)
+		}
+		ast, _ := calculation.ParseAST(expr)
+		res, _ := calculation.Optimize(ast)
+		if !res.IsLeaf {
+			t.Fatalf("%s: ожидалось число, получено %s", expr, res)
+		}
+		if res.Value != want || math.Signbit(res.Value) != math.Signbit(want) {
+			t.Errorf("%s: получено %v, ожидалось %v", expr, res.Value, want)
+		}
+	}
+}
+
+// в точном режиме степень может не вычислиться, поэтому её нельзя выбрасывать
+func TestOptimizeExact_KeepsPowers(t *testing.T) {
+	if _, err := calculation.ComputeExact("^", []string{"1", "10000000"}); !errors.Is(err, calculation.ErrDomain) {
+		t.Fatalf("Ожидалась ErrDomain, получено %v", err)
+	}
+	for _, expr := range []string{"(1^10000000)*0", "(2^3)^0", "(1^10000000)-(1^10000000)"} {
+		ast, _ := calculation.ParseAST(expr)
+		before := ast.String()
+		res, rewrites := calculation.OptimizeExact(ast)
+		if len(rewrites) != 0 || res.String() != before {
+			t.Errorf("%s: упрощать нельзя, получено %s (%+v)", expr, res, rewrites)
+		}
+	}
+	ast, _ := calculation.ParseAST("(2+3)*1-0")
+	if res, _ := calculation.OptimizeExact(ast); res.String() != "(2+3)" {
+		t.Errorf("Получено %s", res)
+	}
+}
@@ -28,7 +28,7 @@ func TestValid_UnbalancedParentheses(t *testing.T) {
 }
 
 func TestValid_InvalidOperatorPlacement(t *testing.T) {
-	valid := application.Valid("3++2")
+	valid := application.Valid("3+*2")
 	if valid {
 		t.Errorf("Ожидалось false при двойных операторах")
 	}
@@ -40,3 +40,21 @@ func TestValid_InvalidLastCharacter(t *testing.T) {
 		t.Errorf("Ожидалось false при неправильном последнем символе")
 	}
 }
+
+func TestValid_Power(t *testing.T) {
+	if !application.Valid("2^3*(4-1)") {
+		t.Errorf("Ожидалось true для выражения со степенью")
+	}
+	if application.Valid("2^^3") {
+		t.Errorf("Ожидалось false при двойном ^")
+	}
+}
+
+func TestValid_Functions(t *testing.T) {
+	if !application.Valid("sqrt(16)+max(1,2,3)") {
+		t.Errorf("Ожидалось true для выражения с функциями")
+	}
+	if application.Valid("foo(1)") {
+		t.Errorf("Ожидалось false для неизвестной функции")
+	}
+}
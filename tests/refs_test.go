@@ -0,0 +1,48 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+func TestParseAST_References(t *testing.T) {
+	ast, err := calculation.ParseAST("ans * 2 + $aZ3kQ9xP - $aZ3kQ9xP")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	refs := calculation.References(ast)
+	if len(refs) != 2 || refs[0] != calculation.RefLast || refs[1] != "aZ3kQ9xP" {
+		t.Fatalf("Получены ссылки %v", refs)
+	}
+	if got := ast.String(); got != "(((ans*2)+$aZ3kQ9xP)-$aZ3kQ9xP)" {
+		t.Errorf("Получено %s", got)
+	}
+	if _, err := calculation.Eval(ast); !errors.Is(err, calculation.ErrReference) {
+		t.Errorf("Ожидалась ErrReference, получено %v", err)
+	}
+
+	calculation.ReplaceReference(ast, calculation.RefLast, "prev")
+	calculation.BindReference(ast, "prev", calculation.Variable{Value: 5, Exact: "5"})
+	calculation.BindReference(ast, "aZ3kQ9xP", calculation.Variable{Value: 0.5, Exact: "1/2"})
+	if refs := calculation.References(ast); len(refs) != 0 {
+		t.Errorf("Остались ссылки %v", refs)
+	}
+	if res, err := calculation.Eval(ast); err != nil || res != 10 {
+		t.Errorf("Получено %v, %v", res, err)
+	}
+	if ast.Left.Right.Ref != "aZ3kQ9xP" {
+		t.Errorf("Ссылка должна остаться в листе: %+v", ast.Left.Right)
+	}
+}
+
+func TestParseAST_ReferenceErrors(t *testing.T) {
+	var syntaxErr *calculation.SyntaxError
+	if _, err := calculation.ParseAST("2 + $"); !errors.As(err, &syntaxErr) || syntaxErr.Position != 5 {
+		t.Errorf("Ожидалась SyntaxError на позиции 5, получено %v", err)
+	}
+	if calculation.CheckName(calculation.RefLast) == nil {
+		t.Error("Имя ans должно быть занято")
+	}
+}
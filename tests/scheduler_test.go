@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zakharkaverin1/final_calca/internal/application"
+)
+
+func popIDs(s application.Scheduler) []string {
+	var ids []string
+	for t := s.Pop(); t != nil; t = s.Pop() {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestScheduler_RoundRobin(t *testing.T) {
+	s := application.NewScheduler(application.PolicyRoundRobin, 0)
+	// первый пользователь успел поставить три задачи раньше второго
+	s.Push(&application.Task{ID: "a1", UserID: "alice", ExprID: "e1"})
+	s.Push(&application.Task{ID: "a2", UserID: "alice", ExprID: "e1"})
+	s.Push(&application.Task{ID: "a3", UserID: "alice", ExprID: "e2"})
+	s.Push(&application.Task{ID: "b1", UserID: "bob", ExprID: "e3"})
+	s.Push(&application.Task{ID: "b2", UserID: "bob", ExprID: "e3"})
+
+	want := []string{"a1", "b1", "a2", "b2", "a3"}
+	if got := popIDs(s); !equalIDs(got, want) {
+		t.Errorf("Ожидался порядок %v, получен %v", want, got)
+	}
+}
+
+func TestScheduler_FIFO(t *testing.T) {
+	s := application.NewScheduler(application.PolicyFIFO, 0)
+	s.Push(&application.Task{ID: "a1", UserID: "alice"})
+	s.Push(&application.Task{ID: "a2", UserID: "alice"})
+	s.Push(&application.Task{ID: "b1", UserID: "bob"})
+
+	want := []string{"a1", "a2", "b1"}
+	if got := popIDs(s); !equalIDs(got, want) {
+		t.Errorf("Ожидался порядок %v, получен %v", want, got)
+	}
+}
+
+func TestScheduler_PushFrontAndRemove(t *testing.T) {
+	s := application.NewScheduler(application.PolicyRoundRobin, 0)
+	s.Push(&application.Task{ID: "a1", UserID: "alice", ExprID: "e1"})
+	s.Push(&application.Task{ID: "a2", UserID: "alice", ExprID: "e2"})
+	s.Push(&application.Task{ID: "b1", UserID: "bob", ExprID: "e3"})
+	s.PushFront(&application.Task{ID: "a0", UserID: "alice", ExprID: "e2"})
+	s.Remove("e1")
+
+	if s.Len() != 3 {
+		t.Fatalf("Ожидалось 3 задачи, получено %d", s.Len())
+	}
+	want := []string{"a0", "b1", "a2"}
+	if got := popIDs(s); !equalIDs(got, want) {
+		t.Errorf("Ожидался порядок %v, получен %v", want, got)
+	}
+}
+
+func TestScheduler_Priority(t *testing.T) {
+	s := application.NewScheduler(application.PolicyRoundRobin, 0)
+	s.Push(&application.Task{ID: "a1", UserID: "alice", ExprID: "e1"})
+	s.Push(&application.Task{ID: "b1", UserID: "bob", ExprID: "e2", Priority: 5})
+	s.Push(&application.Task{ID: "a2", UserID: "alice", ExprID: "e3", Priority: 5})
+
+	// сначала верхний класс по кругу пользователей, затем остальное
+	want := []string{"a2", "b1", "a1"}
+	if got := popIDs(s); !equalIDs(got, want) {
+		t.Errorf("Ожидался порядок %v, получен %v", want, got)
+	}
+}
+
+func TestScheduler_AgingPreventsStarvation(t *testing.T) {
+	s := application.NewScheduler(application.PolicyFIFO, time.Second)
+	// задача с нулевым приоритетом ждёт уже 10 секунд и догнала приоритет 9
+	s.Push(&application.Task{ID: "old", ExprID: "e1", QueuedAt: time.Now().Add(-10 * time.Second)})
+	s.Push(&application.Task{ID: "new", ExprID: "e2", Priority: 9})
+
+	want := []string{"old", "new"}
+	if got := popIDs(s); !equalIDs(got, want) {
+		t.Errorf("Ожидался порядок %v, получен %v", want, got)
+	}
+}
+
+func TestScheduler_AgingRoundRobin(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		aging time.Duration
+		want  []string
+	}{
+		// без старения приоритет решает всё
+		{"off", 0, []string{"new", "old"}},
+		// за 10 секунд задача поднялась на 10 уровней и обогнала приоритет 9
+		{"on", time.Second, []string{"old", "new"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := application.NewScheduler(application.PolicyRoundRobin, tc.aging)
+			s.Push(&application.Task{ID: "old", UserID: "alice", ExprID: "e1", QueuedAt: time.Now().Add(-10 * time.Second)})
+			s.Push(&application.Task{ID: "new", UserID: "bob", ExprID: "e2", Priority: 9})
+
+			if got := popIDs(s); !equalIDs(got, tc.want) {
+				t.Errorf("Ожидался порядок %v, получен %v", tc.want, got)
+			}
+		})
+	}
+}
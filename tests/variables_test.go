@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/zakharkaverin1/final_calca/pkg/calculation"
+)
+
+func TestParseASTWith_Variables(t *testing.T) {
+	rate, err := calculation.ParseVariable("0.15")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	vars := map[string]calculation.Variable{"rate": rate, "base_2": {Value: 200, Exact: "200"}}
+
+	ast, err := calculation.ParseASTWith("base_2 * rate + pi", vars)
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	res, err := calculation.Eval(ast)
+	if err != nil || res != 200*0.15+math.Pi {
+		t.Errorf("Получено %v, %v", res, err)
+	}
+	used := calculation.UsedVariables(ast)
+	if len(used) != 3 || used["rate"].Exact != "3/20" || used["pi"].Value != math.Pi {
+		t.Errorf("Неверный снимок переменных: %+v", used)
+	}
+
+	// без переменных доступны только константы
+	if _, err := calculation.ParseAST("base_2 * 2"); err == nil {
+		t.Error("Ожидалась ошибка для неизвестного имени")
+	}
+	var syntaxErr *calculation.SyntaxError
+	if _, err := calculation.ParseAST("2 + foo"); !errors.As(err, &syntaxErr) || syntaxErr.Position != 4 {
+		t.Errorf("Ожидалась SyntaxError на позиции 4, получено %v", err)
+	}
+	if res, err := calculation.Calc("cos(pi) + e - e"); err != nil || res != -1 {
+		t.Errorf("Получено %v, %v", res, err)
+	}
+}
+
+func TestCheckExact_Constants(t *testing.T) {
+	ast, _ := calculation.ParseAST("2*pi")
+	if err := calculation.CheckExact(ast); !errors.Is(err, calculation.ErrInexact) {
+		t.Errorf("Ожидалась ErrInexact, получено %v", err)
+	}
+	third, _ := calculation.ParseVariable("1/3")
+	ast, _ = calculation.ParseASTWith("third*3", map[string]calculation.Variable{"third": third})
+	if err := calculation.CheckExact(ast); err != nil {
+		t.Errorf("Неожиданная ошибка: %v", err)
+	}
+	if got := ast.ExactString(); got != "(1/3*3)" {
+		t.Errorf("Получено %s", got)
+	}
+}
+
+func TestCheckName(t *testing.T) {
+	for _, name := range []string{"x", "rate", "tax_2"} {
+		if err := calculation.CheckName(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "2x", "pi", "sqrt", "a-b", "_x"} {
+		if calculation.CheckName(name) == nil {
+			t.Errorf("Ожидалась ошибка для %q", name)
+		}
+	}
+}
+
+func TestParseVariable(t *testing.T) {
+	cases := map[string]string{"-0xFF": "-255", "1e-3": "1/1000", "2/-4": "-1/2", "1_000": "1000"}
+	for in, want := range cases {
+		v, err := calculation.ParseVariable(in)
+		if err != nil || v.Exact != want {
+			t.Errorf("%q: получено %+v, %v, ожидалось %s", in, v, err, want)
+		}
+	}
+	for _, in := range []string{"", "abc", "1/0", "1.2.3", "1e999", "2+3"} {
+		if _, err := calculation.ParseVariable(in); err == nil {
+			t.Errorf("Ожидалась ошибка для %q", in)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zakharkaverin1/final_calca/internal/application"
+)
+
+func TestWebhookSender_RetriesUntilSuccess(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		calls    int
+		bodies   [][]byte
+		sigs     []string
+		attempts []application.WebhookDelivery
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		bodies = append(bodies, body)
+		sigs = append(sigs, r.Header.Get(application.WebhookSignatureHeader))
+		// первые две попытки получатель «лежит»
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	sender := &application.WebhookSender{
+		Client:      receiver.Client(),
+		MaxAttempts: 5,
+		Record:      func(d application.WebhookDelivery) { attempts = append(attempts, d) },
+	}
+	payload := []byte(`{"event":"expression.finished","id":"e1","result":6}`)
+	target := application.WebhookTarget{URL: receiver.URL, Secret: "s3cret"}
+	if !sender.Send("e1", target, payload) {
+		t.Fatal("Ожидалась успешная доставка")
+	}
+
+	if calls != 3 {
+		t.Fatalf("Ожидалось 3 попытки, получено %d", calls)
+	}
+	want := application.SignPayload("s3cret", payload)
+	for i := range bodies {
+		if string(bodies[i]) != string(payload) {
+			t.Errorf("Попытка %d: тело %s", i+1, bodies[i])
+		}
+		if sigs[i] != want {
+			t.Errorf("Попытка %d: подпись %q, ожидалась %q", i+1, sigs[i], want)
+		}
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("В журнале %d попыток, ожидалось 3", len(attempts))
+	}
+	if attempts[0].Success || attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Первая попытка записана неверно: %+v", attempts[0])
+	}
+	if !attempts[2].Success || attempts[2].Attempt != 3 {
+		t.Errorf("Последняя попытка записана неверно: %+v", attempts[2])
+	}
+}
+
+func TestWebhookSender_GivesUp(t *testing.T) {
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	sender := &application.WebhookSender{Client: receiver.Client(), MaxAttempts: 2}
+	if sender.Send("e1", application.WebhookTarget{URL: receiver.URL}, []byte(`{}`)) {
+		t.Fatal("Ожидалась неудачная доставка")
+	}
+	if calls != 2 {
+		t.Errorf("Ожидалось 2 попытки, получено %d", calls)
+	}
+}
+
+func TestWebhookSender_StopInterruptsRetries(t *testing.T) {
+	first := make(chan struct{}, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case first <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	sender := &application.WebhookSender{Client: receiver.Client(), MaxAttempts: 5, BaseDelay: time.Hour}
+	done := make(chan bool)
+	go func() { done <- sender.Send("e1", application.WebhookTarget{URL: receiver.URL}, []byte(`{}`)) }()
+	<-first
+	sender.Stop()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("Ожидалась неудачная доставка")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop не прервал ожидание повтора")
+	}
+}